@@ -49,6 +49,34 @@ func New(config Config) (*cobra.Command, error) {
 		}
 	}
 
+	var planCmd *cobra.Command
+	{
+		c := sync.Config{
+			Logger: config.Logger,
+			Stderr: config.Stderr,
+			Stdout: config.Stdout,
+		}
+
+		planCmd, err = sync.NewPlan(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	var verifyCmd *cobra.Command
+	{
+		c := sync.Config{
+			Logger: config.Logger,
+			Stderr: config.Stderr,
+			Stdout: config.Stdout,
+		}
+
+		verifyCmd, err = sync.NewVerify(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
 	f := &flag{}
 
 	r := &runner{
@@ -70,6 +98,8 @@ func New(config Config) (*cobra.Command, error) {
 	f.Init(c)
 
 	c.AddCommand(syncCmd)
+	c.AddCommand(planCmd)
+	c.AddCommand(verifyCmd)
 
 	return c, nil
 }
@@ -12,6 +12,12 @@ import (
 const (
 	name        = "sync"
 	description = "Synchronize container images between registries."
+
+	planName        = "plan"
+	planDescription = "Show what sync would copy, resync and delete without changing destination registries."
+
+	verifyName        = "verify"
+	verifyDescription = "Verify that destination registries contain all source tags with the same digests."
 )
 
 type Config struct {
@@ -51,3 +57,74 @@ func New(config Config) (*cobra.Command, error) {
 
 	return c, nil
 }
+
+// NewPlan creates the plan command. It lists and compares registries the same
+// way as sync and reports the changes instead of making them.
+func NewPlan(config Config) (*cobra.Command, error) {
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Stderr == nil {
+		config.Stderr = os.Stderr
+	}
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+
+	f := &flag{}
+
+	r := &runner{
+		flag:   f,
+		logger: config.Logger,
+		stderr: config.Stderr,
+		stdout: config.Stdout,
+		plan:   &plan{},
+	}
+
+	c := &cobra.Command{
+		Use:   planName,
+		Short: planDescription,
+		Long:  planDescription,
+		RunE:  r.Run,
+	}
+
+	f.InitPlan(c)
+
+	return c, nil
+}
+
+// NewVerify creates the verify command. It compares all the tags selected for
+// syncing by their digests and fails when any of them is missing or differs
+// in a destination registry.
+func NewVerify(config Config) (*cobra.Command, error) {
+	if config.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+	if config.Stderr == nil {
+		config.Stderr = os.Stderr
+	}
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+
+	f := &flag{}
+
+	r := &runner{
+		flag:         f,
+		logger:       config.Logger,
+		stderr:       config.Stderr,
+		stdout:       config.Stdout,
+		verification: &verification{},
+	}
+
+	c := &cobra.Command{
+		Use:   verifyName,
+		Short: verifyDescription,
+		Long:  verifyDescription,
+		RunE:  r.Run,
+	}
+
+	f.InitVerify(c)
+
+	return c, nil
+}
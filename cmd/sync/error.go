@@ -30,3 +30,30 @@ var executionFailedError = &microerror.Error{
 func IsExecutionFailed(err error) bool {
 	return microerror.Cause(err) == executionFailedError
 }
+
+var verificationFailedError = &microerror.Error{
+	Kind: "verificationFailedError",
+}
+
+// IsVerificationFailed asserts verificationFailedError.
+func IsVerificationFailed(err error) bool {
+	return microerror.Cause(err) == verificationFailedError
+}
+
+var invalidWebhookError = &microerror.Error{
+	Kind: "invalidWebhookError",
+}
+
+// IsInvalidWebhook asserts invalidWebhookError.
+func IsInvalidWebhook(err error) bool {
+	return microerror.Cause(err) == invalidWebhookError
+}
+
+var interruptedError = &microerror.Error{
+	Kind: "interruptedError",
+}
+
+// IsInterrupted asserts interruptedError.
+func IsInterrupted(err error) bool {
+	return microerror.Cause(err) == interruptedError
+}
@@ -3,89 +3,530 @@ package sync
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/giantswarm/microerror"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/giantswarm/crsync/internal/env"
-
-	"github.com/spf13/cobra"
+	"github.com/giantswarm/crsync/pkg/config"
+	"github.com/giantswarm/crsync/pkg/logger"
+	"github.com/giantswarm/crsync/pkg/tracing"
 )
 
 const (
+	flagConfig                     = "config"
+	flagDrainTimeout               = "drain-timeout"
+	flagDstConcurrency             = "dst-concurrency"
+	flagDstRateLimit               = "dst-rate-limit"
 	flagDstRegistryName            = "dst-name"
 	flagDstRegistryUser            = "dst-user"
 	flagDstRegistryPassword        = "dst-password"
+	flagDstRegistryInsecure        = "dst-insecure"
+	flagFullSyncEvery              = "full-sync-every"
+	flagFullSyncSchedule           = "full-sync-schedule"
+	flagRepositoryWorkers          = "repository-workers"
+	flagRetryAttempts              = "retry-attempts"
+	flagRetryBackoff               = "retry-backoff"
+	flagRetryMaxBackoff            = "retry-max-backoff"
+	flagSrcConcurrency             = "src-concurrency"
+	flagSrcRateLimit               = "src-rate-limit"
 	flagSrcRegistryName            = "src-name"
 	flagSrcRegistryUser            = "src-user"
 	flagSrcRegistryPassword        = "src-password"
+	flagSrcRegistryInsecure        = "src-insecure"
+	flagSrcRegistryNamespace       = "src-namespace"
 	flagLastModified               = "last-modified"
+	flagLogFormat                  = "log-format"
+	flagLogLevel                   = "log-level"
 	flagLoop                       = "loop"
+	flagMirror                     = "mirror"
+	flagMirrorDryRun               = "mirror-dry-run"
 	flagIncludePrivateRepositories = "include-private-repositories"
+	flagHealthPort                 = "health-port"
 	flagMetricsPort                = "metrics-port"
+	flagOutput                     = "output"
+	flagOutputFile                 = "output-file"
 	flagQuayAPIToken               = "quay-api-token" // nolint
+	flagSchedule                   = "schedule"
+	flagStateFile                  = "state-file"
 	flagSyncInterval               = "sync-interval"
+	flagSyncJitter                 = "sync-jitter"
+	flagTagInclude                 = "tag-include"
+	flagTagExclude                 = "tag-exclude"
+	flagTagSemver                  = "tag-semver"
+	flagTagExcludePrereleases      = "tag-exclude-prereleases"
+	flagTagWorkers                 = "tag-workers"
+	flagTracingEndpoint            = "tracing-endpoint"
+	flagTracingExporter            = "tracing-exporter"
+	flagTracingFile                = "tracing-file"
+	flagTracingInsecure            = "tracing-insecure"
+	flagVerifyBlobs                = "verify-blobs"
+	flagWebhookPort                = "webhook-port"
+	flagWebhookSecret              = "webhook-secret" // nolint
 )
 
+const (
+	outputJSON  = "json"
+	outputTable = "table"
+)
+
+// defaultSrcRegistryNamespace is synced from Quay and Docker Hub when no
+// namespace is given without the configuration file.
+const defaultSrcRegistryNamespace = "giantswarm"
+
 type flag struct {
-	DstRegistryName            string
-	DstRegistryUser            string
-	DstRegistryPassword        string
+	Config                     string
+	DrainTimeout               time.Duration
+	DstConcurrency             int
+	DstRateLimits              []string
+	DstRegistryNames           []string
+	DstRegistryUsers           []string
+	DstRegistryPasswords       []string
+	DstRegistryInsecure        bool
+	FullSyncEvery              int
+	FullSyncSchedule           string
+	RepositoryWorkers          int
+	RetryAttempts              int
+	RetryBackoff               time.Duration
+	RetryMaxBackoff            time.Duration
+	SrcConcurrency             int
+	SrcRateLimits              []string
 	SrcRegistryName            string
 	SrcRegistryUser            string
 	SrcRegistryPassword        string
+	SrcRegistryInsecure        bool
+	SrcRegistryNamespaces      []string
 	LastModified               time.Duration
+	LogFormat                  string
+	LogLevel                   string
 	Loop                       bool
+	Mirror                     bool
+	MirrorDryRun               bool
 	IncludePrivateRepositories bool
+	HealthPort                 int
 	MetricsPort                int
+	Output                     string
+	OutputFile                 string
 	QuayAPIToken               string
+	Schedule                   string
+	StateFile                  string
 	SyncInterval               int
+	SyncJitter                 time.Duration
+	TagInclude                 []string
+	TagExclude                 []string
+	TagSemver                  string
+	TagExcludePrereleases      bool
+	TagWorkers                 int
+	TracingEndpoint            string
+	TracingExporter            string
+	TracingFile                string
+	TracingInsecure            bool
+	VerifyBlobs                bool
+	WebhookPort                int
+	WebhookSecret              string
+
+	flags *pflag.FlagSet
+	// config is the configuration loaded from the --config file with flags
+	// applied on top of it. It is set by Validate.
+	config *config.Config
 }
 
 func (f *flag) Init(cmd *cobra.Command) {
-	cmd.Flags().StringVar(&f.DstRegistryName, flagDstRegistryName, "", `Destination container registry name. E.g.: "docker.io".`)
-	cmd.Flags().StringVar(&f.DstRegistryUser, flagDstRegistryUser, "", `Destination container registry user.`)
-	cmd.Flags().StringVar(&f.DstRegistryPassword, flagDstRegistryPassword, "", fmt.Sprintf(`Destination container registry password. Defaults to %s environment variable.`, env.DstRegistryPassword))
+	f.init(cmd)
+
+	cmd.Flags().IntVar(&f.FullSyncEvery, flagFullSyncEvery, 0, fmt.Sprintf("Make every N-th iteration a full sync comparing all the repositories and tags regardless of --%s and the sync state. 0 disables full syncs.", flagLastModified))
+	cmd.Flags().StringVar(&f.FullSyncSchedule, flagFullSyncSchedule, "", fmt.Sprintf(`Cron expression, e.g. "0 3 * * *", starting full syncs when running in a loop. Can not be used together with --%s.`, flagFullSyncEvery))
+	cmd.Flags().BoolVar(&f.Loop, flagLoop, false, "Whether to run the job continuously.")
+	cmd.Flags().IntVar(&f.MetricsPort, flagMetricsPort, 0, "Port on which metrics are served. 0 disables metrics.")
+	cmd.Flags().IntVar(&f.HealthPort, flagHealthPort, 8081, fmt.Sprintf("Port on which /healthz, /readyz and /status are served when running in a loop. It can be the same as --%s.", flagMetricsPort))
+	cmd.Flags().StringVar(&f.StateFile, flagStateFile, "", "Path to the file persisting the sync state across restarts. When empty the state is kept in memory.")
+	cmd.Flags().StringVar(&f.Schedule, flagSchedule, "", fmt.Sprintf(`Cron expression, e.g. "*/5 * * * *", starting incremental syncs when running in a loop instead of --%s.`, flagSyncInterval))
+	cmd.Flags().IntVar(&f.SyncInterval, flagSyncInterval, 30, "Interval(seconds) between the end of a sync and the start of the next one when running in a loop.")
+	cmd.Flags().DurationVar(&f.SyncJitter, flagSyncJitter, 0, "Maximum random delay added to the start of every incremental sync when running in a loop.")
+	cmd.Flags().IntVar(&f.WebhookPort, flagWebhookPort, 0, fmt.Sprintf("Port on which push notifications triggering immediate sync of pushed repositories are received when running in a loop. 0 disables the webhook. Requires --%s.", flagWebhookSecret))
+	cmd.Flags().StringVar(&f.WebhookSecret, flagWebhookSecret, "", fmt.Sprintf("Secret push notifications must be sent with. Defaults to %s environment variable.", env.WebhookSecret))
+}
+
+// InitPlan registers flags of the plan command. It shares all the flags
+// selecting what to sync with the sync command.
+func (f *flag) InitPlan(cmd *cobra.Command) {
+	f.init(cmd)
+
+	f.initOutput(cmd)
+}
+
+// InitVerify registers flags of the verify command. It shares all the flags
+// selecting what to sync with the sync command.
+func (f *flag) InitVerify(cmd *cobra.Command) {
+	f.init(cmd)
+	f.initOutput(cmd)
+
+	cmd.Flags().BoolVar(&f.VerifyBlobs, flagVerifyBlobs, false, "Whether to check that all blobs of verified tags exist in destination registries.")
+}
+
+func (f *flag) initOutput(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.Output, flagOutput, outputTable, fmt.Sprintf("Format of the report. One of %q or %q.", outputTable, outputJSON))
+	cmd.Flags().StringVar(&f.OutputFile, flagOutputFile, "", "File to write the report to instead of the standard output.")
+}
+
+func (f *flag) init(cmd *cobra.Command) {
+	f.flags = cmd.Flags()
+
+	cmd.Flags().StringVar(&f.Config, flagConfig, "", `Path to YAML sync configuration file. Other flags override values from the file.`)
+	cmd.Flags().DurationVar(&f.DrainTimeout, flagDrainTimeout, time.Minute, `Time running jobs are given to finish after receiving SIGTERM or SIGINT before they are cancelled.`)
+	cmd.Flags().StringArrayVar(&f.DstRegistryNames, flagDstRegistryName, nil, `Destination container registry name. Can be given multiple times to sync to multiple registries. E.g.: "docker.io".`)
+	cmd.Flags().StringArrayVar(&f.DstRegistryUsers, flagDstRegistryUser, nil, `Destination container registry user. Given once it is used for all destinations, otherwise it must be given for every destination in the same order.`)
+	cmd.Flags().StringArrayVar(&f.DstRegistryPasswords, flagDstRegistryPassword, nil, fmt.Sprintf(`Destination container registry password. Given once it is used for all destinations, otherwise it must be given for every destination in the same order. Defaults to %s environment variable.`, env.DstRegistryPassword))
+	cmd.Flags().BoolVar(&f.DstRegistryInsecure, flagDstRegistryInsecure, false, `Whether to connect to destination container registries over plain HTTP or without TLS verification.`)
 	cmd.Flags().StringVar(&f.SrcRegistryName, flagSrcRegistryName, "", `Source container registry name. E.g.: "quay.io".`)
 	cmd.Flags().StringVar(&f.SrcRegistryUser, flagSrcRegistryUser, "", `Source container registry user.`)
 	cmd.Flags().StringVar(&f.SrcRegistryPassword, flagSrcRegistryPassword, "", fmt.Sprintf(`Source container registry password. Defaults to %s environment variable.`, env.SrcRegistryPassword))
+	cmd.Flags().BoolVar(&f.SrcRegistryInsecure, flagSrcRegistryInsecure, false, `Whether to connect to source container registry over plain HTTP or without TLS verification.`)
+	cmd.Flags().StringSliceVar(&f.SrcRegistryNamespaces, flagSrcRegistryNamespace, nil, fmt.Sprintf(`Source container registry namespaces, i.e. organizations or users, to sync. Can be given multiple times. Defaults to %q for "quay.io" and "docker.io" without --%s.`, defaultSrcRegistryNamespace, flagConfig))
 	cmd.Flags().DurationVar(&f.LastModified, flagLastModified, time.Hour, `Duration in time when source repository was last modified.`)
-	cmd.Flags().BoolVar(&f.Loop, flagLoop, false, "Whether to run the job continuously.")
+	cmd.Flags().StringVar(&f.LogFormat, flagLogFormat, logger.FormatJSON, fmt.Sprintf("Format of log messages. One of %q or %q.", logger.FormatJSON, logger.FormatText))
+	cmd.Flags().StringVar(&f.LogLevel, flagLogLevel, logger.LevelInfo, fmt.Sprintf("Lowest level of logged messages. One of %q, %q, %q or %q. Progress of every tag is logged in %q level.", logger.LevelDebug, logger.LevelInfo, logger.LevelWarning, logger.LevelError, logger.LevelDebug))
+	cmd.Flags().StringVar(&f.TracingExporter, flagTracingExporter, "", fmt.Sprintf("Exporter of OpenTelemetry spans. One of %q, %q or %q. Empty disables tracing. Standard OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER* environment variables are honored.", tracing.ExporterOTLPGRPC, tracing.ExporterOTLPHTTP, tracing.ExporterStdout))
+	cmd.Flags().StringVar(&f.TracingEndpoint, flagTracingEndpoint, "", `Host and port of the OTLP collector spans are exported to. E.g.: "otel-collector:4317".`)
+	cmd.Flags().BoolVar(&f.TracingInsecure, flagTracingInsecure, false, "Whether to export spans to the OTLP collector without TLS.")
+	cmd.Flags().StringVar(&f.TracingFile, flagTracingFile, "", fmt.Sprintf("File the %q exporter writes spans to instead of the standard error.", tracing.ExporterStdout))
+	cmd.Flags().BoolVar(&f.Mirror, flagMirror, false, "Whether to delete destination tags which do not exist in the source registry.")
+	cmd.Flags().BoolVar(&f.MirrorDryRun, flagMirrorDryRun, false, fmt.Sprintf("Whether to only report tags which would be deleted with --%s.", flagMirror))
 	cmd.Flags().BoolVar(&f.IncludePrivateRepositories, flagIncludePrivateRepositories, false, "Whether to synchronize private repositories.")
-	cmd.Flags().IntVar(&f.MetricsPort, flagMetricsPort, 0, "Port on which metrics are served. 0 disables metrics.")
 	cmd.Flags().StringVar(&f.QuayAPIToken, flagQuayAPIToken, "", fmt.Sprintf(`Quay container registry API token. Defaults to %s environment variable.`, env.QuayAPIToken))
-	cmd.Flags().IntVar(&f.SyncInterval, flagSyncInterval, 30, "Interval(seconds) between two syncs when running in a loop.")
-
+	cmd.Flags().StringArrayVar(&f.TagInclude, flagTagInclude, nil, "Regular expression matching tags to sync. Can be given multiple times.")
+	cmd.Flags().StringArrayVar(&f.TagExclude, flagTagExclude, nil, "Regular expression matching tags not to sync. Can be given multiple times.")
+	cmd.Flags().StringVar(&f.TagSemver, flagTagSemver, "", `Semantic version constraint tags must satisfy to be synced. E.g.: ">=1.0.0".`)
+	cmd.Flags().BoolVar(&f.TagExcludePrereleases, flagTagExcludePrereleases, false, "Whether to skip tags being semantic versions with prerelease part.")
+	cmd.Flags().IntVar(&f.RepositoryWorkers, flagRepositoryWorkers, 0, "Number of repositories which tags are listed and compared in parallel. Defaults to 100.")
+	cmd.Flags().IntVar(&f.TagWorkers, flagTagWorkers, 0, "Number of tags copied in parallel. Defaults to 10.")
+	cmd.Flags().IntVar(&f.SrcConcurrency, flagSrcConcurrency, 0, "Maximum number of images pulled from source container registry at the same time. 0 means it is only limited by --tag-workers.")
+	cmd.Flags().IntVar(&f.DstConcurrency, flagDstConcurrency, 0, "Maximum number of images pushed to every destination container registry at the same time. 0 means it is only limited by --tag-workers.")
+	cmd.Flags().StringArrayVar(&f.SrcRateLimits, flagSrcRateLimit, nil, `Rate limit of source container registry operation in "operation=interval[:burst]" format. Operation is one of "listRepositories", "listTags", "digest", "pull", "push" or "delete". Interval 0 disables the limit. Can be given multiple times. E.g.: "pull=500ms:20".`)
+	cmd.Flags().StringArrayVar(&f.DstRateLimits, flagDstRateLimit, nil, fmt.Sprintf(`Rate limit of destination container registries operation in the same format as --%s. Applies to all destinations. Can be given multiple times.`, flagSrcRateLimit))
+	cmd.Flags().IntVar(&f.RetryAttempts, flagRetryAttempts, 0, "Maximum number of attempts of every registry operation failing with transient errors like 5xx, 429, timeouts or broken connections. 1 disables retries. Defaults to 3.")
+	cmd.Flags().DurationVar(&f.RetryBackoff, flagRetryBackoff, 0, "Delay before the first retry of a registry operation. It doubles with every retry and is randomized. Defaults to 1s.")
+	cmd.Flags().DurationVar(&f.RetryMaxBackoff, flagRetryMaxBackoff, 0, "Longest delay between retries of a registry operation. Defaults to 30s.")
 }
 
 func (f *flag) Validate() error {
-	if f.DstRegistryName == "" {
-		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagDstRegistryName)
+	var err error
+
+	if f.DrainTimeout < 0 {
+		return microerror.Maskf(invalidFlagError, "--%s must not be negative", flagDrainTimeout)
+	}
+	if f.Loop && f.HealthPort <= 0 {
+		return microerror.Maskf(invalidFlagError, "--%s must be positive", flagHealthPort)
+	}
+	if f.Output != "" && f.Output != outputTable && f.Output != outputJSON {
+		return microerror.Maskf(invalidFlagError, "--%s must be one of %#q or %#q", flagOutput, outputTable, outputJSON)
+	}
+	if f.LogFormat != logger.FormatJSON && f.LogFormat != logger.FormatText {
+		return microerror.Maskf(invalidFlagError, "--%s must be one of %#q or %#q", flagLogFormat, logger.FormatJSON, logger.FormatText)
+	}
+	switch f.LogLevel {
+	case logger.LevelDebug, logger.LevelInfo, logger.LevelWarning, logger.LevelError:
+	default:
+		return microerror.Maskf(invalidFlagError, "--%s must be one of %#q, %#q, %#q or %#q", flagLogLevel, logger.LevelDebug, logger.LevelInfo, logger.LevelWarning, logger.LevelError)
+	}
+	switch f.TracingExporter {
+	case "", tracing.ExporterOTLPGRPC, tracing.ExporterOTLPHTTP, tracing.ExporterStdout:
+	default:
+		return microerror.Maskf(invalidFlagError, "--%s must be one of %#q, %#q or %#q", flagTracingExporter, tracing.ExporterOTLPGRPC, tracing.ExporterOTLPHTTP, tracing.ExporterStdout)
+	}
+	if f.TracingFile != "" && f.TracingExporter != tracing.ExporterStdout {
+		return microerror.Maskf(invalidFlagError, "--%s requires --%s %#q", flagTracingFile, flagTracingExporter, tracing.ExporterStdout)
+	}
+	if f.flags.Changed(flagSchedule) && f.flags.Changed(flagSyncInterval) {
+		return microerror.Maskf(invalidFlagError, "--%s and --%s must not be set together", flagSchedule, flagSyncInterval)
+	}
+
+	c := &config.Config{}
+	if f.Config != "" {
+		c, err = config.Load(f.Config)
+		if err != nil {
+			return microerror.Maskf(invalidFlagError, "--%s is invalid: %s", flagConfig, err)
+		}
+	}
+
+	err = f.override(c)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	c.Default()
+
+	// Without the configuration file report errors in terms of flags.
+	if f.Config == "" {
+		err = validateFlags(c)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	err = c.Validate()
+	if err != nil {
+		if f.Config == "" {
+			return microerror.Maskf(invalidFlagError, "invalid flags: %s", err)
+		}
+		return microerror.Maskf(invalidFlagError, "--%s %#q is invalid: %s", flagConfig, f.Config, err)
+	}
+
+	if (c.Source.Name == quayRegistryName || c.Source.Name == dockerHubRegistryName) && len(c.Source.Namespaces) == 0 {
+		return microerror.Maskf(invalidFlagError, "--%s %#q is invalid: source.namespaces must not be empty for %#q", flagConfig, f.Config, c.Source.Name)
+	}
+
+	if f.Loop && c.Webhook.Port != 0 && (c.Webhook.Port == f.HealthPort || c.Webhook.Port == f.MetricsPort) {
+		return microerror.Maskf(invalidFlagError, "webhook port %d must differ from --%s and --%s", c.Webhook.Port, flagHealthPort, flagMetricsPort)
+	}
+
+	f.config = c
+
+	return nil
+}
+
+// override applies flags on top of the configuration. Without the
+// configuration file all the flags are applied including their defaults.
+func (f *flag) override(c *config.Config) error {
+	set := func(name string) bool {
+		return f.Config == "" || f.flags.Changed(name)
+	}
+
+	if set(flagSrcRegistryName) {
+		c.Source.Name = f.SrcRegistryName
 	}
-	if f.DstRegistryUser == "" {
-		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagDstRegistryUser)
+	if set(flagSrcRegistryUser) {
+		c.Source.Credentials.User = f.SrcRegistryUser
 	}
-	if f.DstRegistryPassword == "" {
-		f.DstRegistryPassword = os.Getenv(env.DstRegistryPassword)
+	if set(flagSrcRegistryPassword) {
+		c.Source.Credentials.Password.Value = f.SrcRegistryPassword
 	}
-	if f.DstRegistryPassword == "" {
-		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagDstRegistryPassword)
+	if c.Source.Credentials.Password.Value == "" {
+		c.Source.Credentials.Password.Value = os.Getenv(env.SrcRegistryPassword)
 	}
-	if f.SrcRegistryName == "" {
+	if set(flagSrcRegistryInsecure) {
+		c.Source.Insecure = f.SrcRegistryInsecure
+	}
+	if set(flagSrcRegistryNamespace) {
+		c.Source.Namespaces = f.SrcRegistryNamespaces
+	}
+	// Only the giantswarm namespace was synced before namespaces were
+	// configurable. Invocations without the configuration file keep
+	// syncing it when no namespace is given.
+	if f.Config == "" && len(c.Source.Namespaces) == 0 && (c.Source.Name == quayRegistryName || c.Source.Name == dockerHubRegistryName) {
+		c.Source.Namespaces = []string{defaultSrcRegistryNamespace}
+	}
+	if set(flagLastModified) {
+		c.Source.LastModified = f.LastModified
+	}
+	if set(flagIncludePrivateRepositories) {
+		c.Source.IncludePrivateRepositories = f.IncludePrivateRepositories
+	}
+	if set(flagQuayAPIToken) {
+		c.Source.QuayAPIToken.Value = f.QuayAPIToken
+	}
+	if c.Source.Name == quayRegistryName && c.Source.QuayAPIToken.Value == "" {
+		c.Source.QuayAPIToken.Value = os.Getenv(env.QuayAPIToken)
+	}
+
+	if set(flagRepositoryWorkers) {
+		c.Workers.Repositories = f.RepositoryWorkers
+	}
+	if set(flagTagWorkers) {
+		c.Workers.Tags = f.TagWorkers
+	}
+	if set(flagSrcConcurrency) {
+		c.Source.Limits.Concurrency = f.SrcConcurrency
+	}
+	if set(flagSrcRateLimit) {
+		err := setRateLimits(flagSrcRateLimit, f.SrcRateLimits, &c.Source.Limits)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	if set(flagStateFile) {
+		c.State.Path = f.StateFile
+	}
+	if set(flagFullSyncEvery) {
+		c.FullSync.Every = f.FullSyncEvery
+	}
+	if set(flagFullSyncSchedule) {
+		c.Schedule.Full.Cron = f.FullSyncSchedule
+	}
+	switch {
+	case set(flagSchedule) && f.Schedule != "":
+		c.Schedule.Incremental.Cron = f.Schedule
+		c.Schedule.Incremental.Interval = 0
+	case set(flagSyncInterval):
+		c.Schedule.Incremental.Cron = ""
+		c.Schedule.Incremental.Interval = time.Duration(f.SyncInterval) * time.Second
+	}
+	if set(flagSyncJitter) {
+		c.Schedule.Incremental.Jitter = f.SyncJitter
+	}
+
+	if set(flagWebhookPort) {
+		c.Webhook.Port = f.WebhookPort
+	}
+	if set(flagWebhookSecret) {
+		c.Webhook.Secret.Value = f.WebhookSecret
+	}
+	if c.Webhook.Secret.Value == "" {
+		c.Webhook.Secret.Value = os.Getenv(env.WebhookSecret)
+	}
+
+	if set(flagTagInclude) {
+		c.Repositories.Tags.Include = f.TagInclude
+	}
+	if set(flagTagExclude) {
+		c.Repositories.Tags.Exclude = f.TagExclude
+	}
+	if set(flagTagSemver) {
+		c.Repositories.Tags.Semver = f.TagSemver
+	}
+	if set(flagTagExcludePrereleases) {
+		c.Repositories.Tags.ExcludePrereleases = f.TagExcludePrereleases
+	}
+
+	if set(flagDstRegistryName) {
+		c.Destinations = nil
+		for _, n := range f.DstRegistryNames {
+			c.Destinations = append(c.Destinations, config.Destination{Registry: config.Registry{Name: n}})
+		}
+	}
+	if set(flagDstRegistryUser) && len(f.DstRegistryUsers) > 0 {
+		if len(f.DstRegistryUsers) != 1 && len(f.DstRegistryUsers) != len(c.Destinations) {
+			return microerror.Maskf(invalidFlagError, "--%s must be given once or once for every destination", flagDstRegistryUser)
+		}
+		for i := range c.Destinations {
+			c.Destinations[i].Credentials.User = pick(f.DstRegistryUsers, i)
+		}
+	}
+	if set(flagDstRegistryPassword) && len(f.DstRegistryPasswords) > 0 {
+		if len(f.DstRegistryPasswords) != 1 && len(f.DstRegistryPasswords) != len(c.Destinations) {
+			return microerror.Maskf(invalidFlagError, "--%s must be given once or once for every destination", flagDstRegistryPassword)
+		}
+		for i := range c.Destinations {
+			c.Destinations[i].Credentials.Password.Value = pick(f.DstRegistryPasswords, i)
+		}
+	}
+	for i := range c.Destinations {
+		if c.Destinations[i].Credentials.Password.Value == "" {
+			c.Destinations[i].Credentials.Password.Value = os.Getenv(env.DstRegistryPassword)
+		}
+		if set(flagDstRegistryInsecure) {
+			c.Destinations[i].Insecure = f.DstRegistryInsecure
+		}
+		if set(flagMirror) {
+			c.Destinations[i].Mirror.Enabled = f.Mirror
+		}
+		if set(flagMirrorDryRun) {
+			c.Destinations[i].Mirror.DryRun = f.MirrorDryRun
+		}
+		if set(flagDstConcurrency) {
+			c.Destinations[i].Limits.Concurrency = f.DstConcurrency
+		}
+		if set(flagDstRateLimit) {
+			err := setRateLimits(flagDstRateLimit, f.DstRateLimits, &c.Destinations[i].Limits)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+	}
+
+	// Retries apply to the source and all destinations.
+	retries := []*config.Retry{&c.Source.Retry}
+	for i := range c.Destinations {
+		retries = append(retries, &c.Destinations[i].Retry)
+	}
+	for _, r := range retries {
+		if set(flagRetryAttempts) {
+			r.Attempts.Set(f.RetryAttempts)
+		}
+		if set(flagRetryBackoff) {
+			r.Backoff = f.RetryBackoff
+		}
+		if set(flagRetryMaxBackoff) {
+			r.MaxBackoff = f.RetryMaxBackoff
+		}
+	}
+
+	return nil
+}
+
+func validateFlags(c *config.Config) error {
+	if len(c.Destinations) == 0 {
+		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagDstRegistryName)
+	}
+	for _, d := range c.Destinations {
+		if d.Name == "" {
+			return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagDstRegistryName)
+		}
+		if d.Credentials.User == "" {
+			return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagDstRegistryUser)
+		}
+		if d.Credentials.Password.Value == "" {
+			return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagDstRegistryPassword)
+		}
+	}
+	if c.Source.Name == "" {
 		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagSrcRegistryName)
 	}
-	if f.SrcRegistryUser == "" {
-		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagSrcRegistryUser)
+	if c.Webhook.Port != 0 && c.Webhook.Secret.Value == "" {
+		return microerror.Maskf(invalidFlagError, "--%s must not be empty when --%s is set", flagWebhookSecret, flagWebhookPort)
 	}
-	if f.SrcRegistryPassword == "" {
-		f.SrcRegistryPassword = os.Getenv(env.SrcRegistryPassword)
+	if c.Source.Credentials.User == "" {
+		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagSrcRegistryUser)
 	}
-	if f.SrcRegistryPassword == "" {
+	if c.Source.Credentials.Password.Value == "" {
 		return microerror.Maskf(invalidFlagError, "--%s must not be empty", flagSrcRegistryPassword)
 	}
-	if f.SrcRegistryName == sourceRegistryName && f.QuayAPIToken == "" {
-		f.QuayAPIToken = os.Getenv(env.QuayAPIToken)
+
+	return nil
+}
+
+// setRateLimits parses rate limits given in "operation=interval[:burst]"
+// format and sets them in limits.
+func setRateLimits(name string, values []string, limits *config.Limits) error {
+	for _, v := range values {
+		operation, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return microerror.Maskf(invalidFlagError, "--%s %#q must be in %#q format", name, v, "operation=interval[:burst]")
+		}
+
+		r, err := limits.RateLimit(operation)
+		if err != nil {
+			return microerror.Maskf(invalidFlagError, "--%s %#q is invalid: %s", name, v, err)
+		}
+
+		interval, burst, hasBurst := strings.Cut(value, ":")
+
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return microerror.Maskf(invalidFlagError, "--%s %#q has invalid interval: %s", name, v, err)
+		}
+		r.Interval = &d
+		if hasBurst {
+			r.Burst, err = strconv.Atoi(burst)
+			if err != nil {
+				return microerror.Maskf(invalidFlagError, "--%s %#q has invalid burst: %s", name, v, err)
+			}
+		}
 	}
 
 	return nil
 }
+
+// pick returns the only value or the i-th one when there are more values.
+func pick(values []string, i int) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	return values[i]
+}
@@ -1,20 +1,247 @@
 package sync
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 const (
 	prometheusNamespace = "crsync"
 	prometheusSubsystem = "sync"
+
+	// operationCopy is the label of copying a whole image to a destination
+	// registry in operation duration metric.
+	operationCopy = "copy"
+
+	directionPull = "pull"
+	directionPush = "push"
+
+	queueGetTags = "getTags"
+	queueRetag   = "retag"
+
+	triggerFull        = "full"
+	triggerIncremental = "incremental"
+	triggerWebhook     = "webhook"
 )
 
+// durationBuckets range from 10ms to about 5 minutes because copies of big
+// images and listing big registries take much longer than single requests.
+var durationBuckets = prometheus.ExponentialBuckets(0.01, 2, 16)
+
 var (
-	errorsTotal = prometheus.NewCounter(
+	errorsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: prometheusNamespace,
 			Subsystem: prometheusSubsystem,
 			Name:      "errors_total",
 			Help:      "Number of errors occurred while synchronising repositories",
 		},
+		[]string{
+			"registry",
+		},
+	)
+
+	deletedTagsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "deleted_tags_total",
+			Help:      "Number of tags deleted from destination repository because they do not exist in source repository",
+		},
+		[]string{
+			"registry",
+			"repository",
+		},
+	)
+
+	driftedTagsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "drifted_tags_total",
+			Help:      "Number of tags found with a different digest in destination repository",
+		},
+		[]string{
+			"registry",
+			"repository",
+		},
+	)
+
+	iterationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "iteration_duration_seconds",
+			Help:      "Duration of incremental and full sync iterations and iterations started by webhooks",
+			Buckets:   durationBuckets,
+		},
+		[]string{
+			"trigger",
+		},
+	)
+
+	failedRepositories = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "failed_repositories",
+			Help:      "Number of source repositories which last sync failed and which are retried in the next iteration",
+		},
+		[]string{
+			"registry",
+		},
+	)
+
+	lastFullSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "last_full_success_timestamp_seconds",
+			Help:      "Unix time of the last full sync completed without interruption",
+		},
+	)
+
+	lastSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last sync iteration of all repositories completed without interruption",
+		},
+	)
+
+	operationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of registry operations not including waiting for rate limits",
+			Buckets:   durationBuckets,
+		},
+		[]string{
+			"registry",
+			"operation",
+		},
+	)
+
+	queueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "queue_depth",
+			Help:      "Number of jobs waiting for a worker",
+		},
+		[]string{
+			"queue",
+		},
+	)
+
+	replicationLag = newReplicationLagCollector()
+
+	scheduleMetrics = newScheduleCollector()
+
+	skippedRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "skipped_runs_total",
+			Help:      "Number of scheduled runs skipped because an iteration was running",
+		},
+		[]string{
+			"schedule",
+		},
+	)
+
+	syncedTagsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "synced_tags_total",
+			Help:      "Number of tags copied to destination repository",
+		},
+		[]string{
+			"registry",
+			"repository",
+		},
+	)
+
+	transferredBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "transferred_bytes_total",
+			Help:      "Number of bytes of manifests and blobs pulled from or pushed to registry",
+		},
+		[]string{
+			"registry",
+			"direction",
+		},
+	)
+
+	filteredTags = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "filtered_tags",
+			Help:      "Number of tags in source repository skipped by tag filters",
+		},
+		[]string{
+			"registry",
+			"repository",
+		},
+	)
+
+	rateLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "rate_limit",
+			Help:      "Effective rate limit of registry operations per second lowered when registry throttles requests",
+		},
+		[]string{
+			"registry",
+			"operation",
+		},
+	)
+
+	rateLimitRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "rate_limit_remaining",
+			Help:      "Remaining quota of requests reported by registry in RateLimit headers",
+		},
+		[]string{
+			"registry",
+		},
+	)
+
+	retriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "retries_total",
+			Help:      "Number of registry operations retried after failing with transient errors",
+		},
+		[]string{
+			"registry",
+			"operation",
+		},
+	)
+
+	webhookRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: prometheusNamespace,
+			Subsystem: prometheusSubsystem,
+			Name:      "webhook_requests_total",
+			Help:      "Number of received push notifications",
+		},
+		[]string{
+			"type",
+			"status",
+		},
 	)
 
 	tagsTotal = prometheus.NewGaugeVec(
@@ -32,6 +259,154 @@ var (
 )
 
 func init() {
+	prometheus.MustRegister(deletedTagsTotal)
+	prometheus.MustRegister(driftedTagsTotal)
 	prometheus.MustRegister(errorsTotal)
+	prometheus.MustRegister(failedRepositories)
+	prometheus.MustRegister(filteredTags)
+	prometheus.MustRegister(iterationDuration)
+	prometheus.MustRegister(lastFullSuccessTimestamp)
+	prometheus.MustRegister(lastSuccessTimestamp)
+	prometheus.MustRegister(operationDuration)
+	prometheus.MustRegister(queueDepth)
+	prometheus.MustRegister(rateLimit)
+	prometheus.MustRegister(rateLimitRemaining)
+	prometheus.MustRegister(replicationLag)
+	prometheus.MustRegister(retriesTotal)
+	prometheus.MustRegister(scheduleMetrics)
+	prometheus.MustRegister(skippedRunsTotal)
+	prometheus.MustRegister(syncedTagsTotal)
 	prometheus.MustRegister(tagsTotal)
+	prometheus.MustRegister(transferredBytesTotal)
+	prometheus.MustRegister(webhookRequestsTotal)
+}
+
+// replicationLagCollector exports seconds since every source repository was
+// last found completely synced to all its destinations. The lag is computed
+// when metrics are scraped so it grows between syncs and while the
+// repository keeps failing.
+type replicationLagCollector struct {
+	desc *prometheus.Desc
+
+	mutex    sync.Mutex
+	syncedAt map[replicationLagKey]time.Time
+}
+
+type replicationLagKey struct {
+	registry   string
+	repository string
+}
+
+func newReplicationLagCollector() *replicationLagCollector {
+	return &replicationLagCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "replication_lag_seconds"),
+			"Seconds since source repository was last found completely synced to all its destinations",
+			[]string{"registry", "repository"},
+			nil,
+		),
+
+		syncedAt: map[replicationLagKey]time.Time{},
+	}
+}
+
+// Synced records the repository was found completely synced at t.
+func (c *replicationLagCollector) Synced(registry, repository string, t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.syncedAt[replicationLagKey{registry: registry, repository: repository}] = t
+}
+
+// Failed records the repository failed to sync. The lag of repositories which
+// were not synced since the start is counted from lastSyncedAt recorded in
+// the sync state. It is not exported when lastSyncedAt is zero.
+func (c *replicationLagCollector) Failed(registry, repository string, lastSyncedAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	k := replicationLagKey{registry: registry, repository: repository}
+	if _, ok := c.syncedAt[k]; ok || lastSyncedAt.IsZero() {
+		return
+	}
+
+	c.syncedAt[k] = lastSyncedAt
+}
+
+func (c *replicationLagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *replicationLagCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for k, t := range c.syncedAt {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(t).Seconds(), k.registry, k.repository)
+	}
+}
+
+// scheduleCollector exports when the next runs of every schedule are due and
+// whether destinations are in quiet windows. Quiet windows are evaluated
+// when metrics are scraped so they are exported also between iterations.
+type scheduleCollector struct {
+	nextRunDesc     *prometheus.Desc
+	quietWindowDesc *prometheus.Desc
+
+	mutex     sync.Mutex
+	scheduler *scheduler
+}
+
+func newScheduleCollector() *scheduleCollector {
+	return &scheduleCollector{
+		nextRunDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "next_run_timestamp_seconds"),
+			"Unix time the next run of the schedule is due",
+			[]string{"schedule"},
+			nil,
+		),
+		quietWindowDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(prometheusNamespace, prometheusSubsystem, "quiet_window"),
+			"Whether the destination registry is in a quiet window in which nothing is pushed to it",
+			[]string{"registry"},
+			nil,
+		),
+	}
+}
+
+// Set makes the collector export the state of the scheduler.
+func (c *scheduleCollector) Set(s *scheduler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.scheduler = s
+}
+
+func (c *scheduleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nextRunDesc
+	ch <- c.quietWindowDesc
+}
+
+func (c *scheduleCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.scheduler == nil {
+		return
+	}
+
+	now := time.Now()
+	s := c.scheduler.Status(now)
+
+	ch <- prometheus.MustNewConstMetric(c.nextRunDesc, prometheus.GaugeValue, float64(s.NextIncremental.Unix()), scheduleIncremental)
+	if s.NextFull != nil {
+		ch <- prometheus.MustNewConstMetric(c.nextRunDesc, prometheus.GaugeValue, float64(s.NextFull.Unix()), scheduleFull)
+	}
+	for _, d := range c.scheduler.destinations {
+		var quiet float64
+		if c.scheduler.Quiet(d, now) {
+			quiet = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.quietWindowDesc, prometheus.GaugeValue, quiet, d)
+	}
 }
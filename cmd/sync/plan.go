@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	planActionCopy   = "copy"
+	planActionResync = "resync"
+	planActionDelete = "delete"
+)
+
+// planEntry is a single change sync would make in a destination registry.
+type planEntry struct {
+	Registry         string `json:"registry"`
+	Repository       string `json:"repository"`
+	Tag              string `json:"tag"`
+	SourceRepository string `json:"sourceRepository"`
+	SourceTag        string `json:"sourceTag,omitempty"`
+	Action           string `json:"action"`
+	// Bytes is the size of the source image. Blobs already existing in the
+	// destination are not transferred so it is an upper bound.
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+type planSummary struct {
+	Repositories int   `json:"repositories"`
+	Copy         int   `json:"copy"`
+	Resync       int   `json:"resync"`
+	Delete       int   `json:"delete"`
+	Bytes        int64 `json:"bytes"`
+}
+
+// plan collects changes sync would make instead of making them.
+type plan struct {
+	mutex   sync.Mutex
+	entries []planEntry
+}
+
+func (p *plan) Add(e planEntry) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.entries = append(p.entries, e)
+}
+
+// Write renders the plan in the given output format.
+func (p *plan) Write(w io.Writer, output string) error {
+	p.mutex.Lock()
+	entries := append([]planEntry(nil), p.entries...)
+	p.mutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Registry != b.Registry {
+			return a.Registry < b.Registry
+		}
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		return a.Tag < b.Tag
+	})
+
+	var summary planSummary
+	{
+		repos := map[string]bool{}
+		for _, e := range entries {
+			repos[e.SourceRepository] = true
+
+			switch e.Action {
+			case planActionCopy:
+				summary.Copy++
+			case planActionResync:
+				summary.Resync++
+			case planActionDelete:
+				summary.Delete++
+			}
+			summary.Bytes += e.Bytes
+		}
+		summary.Repositories = len(repos)
+	}
+
+	switch output {
+	case outputJSON:
+		type report struct {
+			Entries []planEntry `json:"entries"`
+			Summary planSummary `json:"summary"`
+		}
+
+		if entries == nil {
+			entries = []planEntry{}
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		err := encoder.Encode(report{Entries: entries, Summary: summary})
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(tw, "REGISTRY\tREPOSITORY\tTAG\tACTION\tSOURCE\tSIZE")
+		for _, e := range entries {
+			source := "-"
+			if e.Action != planActionDelete {
+				source = fmt.Sprintf("%s:%s", e.SourceRepository, e.SourceTag)
+			}
+			size := "-"
+			if e.Bytes > 0 {
+				size = units.HumanSize(float64(e.Bytes))
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Registry, e.Repository, e.Tag, e.Action, source, size)
+		}
+
+		err := tw.Flush()
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		fmt.Fprintf(w, "\nPlan: %d repositories, %d tags to copy, %d to resync, %d to delete, up to %s to transfer.\n", summary.Repositories, summary.Copy, summary.Resync, summary.Delete, units.HumanSize(float64(summary.Bytes)))
+	}
+
+	return nil
+}
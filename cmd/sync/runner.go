@@ -2,53 +2,103 @@ package sync
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/containers/image/v5/types"
 	"github.com/giantswarm/microerror"
 	"github.com/giantswarm/micrologger"
+	"github.com/opencontainers/go-digest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/time/rate"
 
-	"github.com/giantswarm/crsync/internal/key"
 	"github.com/giantswarm/crsync/pkg/azurecr"
+	"github.com/giantswarm/crsync/pkg/config"
+	"github.com/giantswarm/crsync/pkg/copier"
+	"github.com/giantswarm/crsync/pkg/distribution"
 	"github.com/giantswarm/crsync/pkg/dockerhub"
+	"github.com/giantswarm/crsync/pkg/logger"
+	"github.com/giantswarm/crsync/pkg/project"
 	"github.com/giantswarm/crsync/pkg/quay"
 	"github.com/giantswarm/crsync/pkg/registry"
+	"github.com/giantswarm/crsync/pkg/state"
+	"github.com/giantswarm/crsync/pkg/tracing"
 )
 
 const (
-	sourceRegistryName = "quay.io"
-
-	getTagsWorkersNum = 100
-	// Docker limits the number of parallel pushes to 5. This limit is
-	// 4 because there is no real speed gain when going above that and we
-	// put unnecessary pressure on the docker daemon. This gives also one
-	// slot left is there is another docker push operation executed on
-	// the node.
-	retagWorkesNum = 4
-	listBurst      = 1
-	// Docker limits the number of parallel pushes to 5 anyway.
-	pullPushBurst = 10
+	dockerHubRegistryName = "docker.io"
+	// dockerHubRegistryHost serves the registry API of Docker Hub.
+	dockerHubRegistryHost = "registry-1.docker.io"
+	quayRegistryName      = "quay.io"
+
 	// Maximum time between logging out and logging in again.
 	loginTTL = 24 * time.Hour
+	// Maximum time pending spans are flushed for before exiting.
+	tracingShutdownTimeout = 10 * time.Second
 )
 
 type runner struct {
 	flag        *flag
 	logger      micrologger.Logger
+	copier      *copier.Copier
 	stdout      io.Writer
 	stderr      io.Writer
-	lastLoginAt *time.Time
+	credentials map[registry.Interface]registryCredentials
+	lastLoginAt map[registry.Interface]time.Time
+	// tracerProvider is passed to registries and the copier. It is nil
+	// when tracing is disabled.
+	tracerProvider trace.TracerProvider
+	tracer         trace.Tracer
+	// throttles adapt rate limits of registries to throttling reported by
+	// them.
+	throttles map[registry.Interface]*registry.Throttle
+	// retries are retry policies of registries also used to retry copying
+	// images which is not done by the registries themselves.
+	retries map[registry.Interface]registry.RetryPolicy
+	// state records what was synced so unchanged repositories can be
+	// skipped, also after a restart with a persistent store.
+	state state.Store
+	// webhooks queues repositories pushed to the source registry to be
+	// synced ahead of the others.
+	webhooks *webhookQueue
+	// status tracks logins, running jobs and iterations served at
+	// /healthz, /readyz and /status.
+	status *status
+	// scheduler decides when iterations start in --loop mode and which
+	// destinations are in quiet windows.
+	scheduler *scheduler
+	// quietLeftOut holds destinations left out of the current iteration
+	// because they are in quiet windows so each is logged once.
+	quietLeftOut map[string]bool
+	quietMutex   sync.Mutex
+	// stopping is closed when the process is asked to terminate. No new
+	// jobs are started afterwards.
+	stopping <-chan struct{}
+	// deletionsLeft is the number of tags which can be still deleted from
+	// the destination registry in the current iteration in mirror mode.
+	deletionsLeft map[registry.Interface]*int64
+	deletionsDone map[registry.Interface]*int64
+	// plan collects changes instead of making them when set.
+	plan *plan
+	// verification collects inconsistencies between registries instead of
+	// syncing them when set.
+	verification *verification
 
 	progressTagsDone   int64
 	progressTagsTotal  int64
@@ -57,28 +107,140 @@ type runner struct {
 }
 
 func (r *runner) Run(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
-
 	err := r.flag.Validate()
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
+	// The format and level of messages are only known once flags are
+	// parsed. Messages go to the standard error so plan and verification
+	// reports written to the standard output stay parsable.
+	{
+		c := logger.Config{
+			Format:   r.flag.LogFormat,
+			Level:    r.flag.LogLevel,
+			IOWriter: r.stderr,
+		}
+
+		r.logger, err = logger.New(c)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	// Spans are flushed after everything else finished so the whole run
+	// is exported.
+	if r.flag.TracingExporter != "" {
+		c := tracing.Config{
+			Exporter: r.flag.TracingExporter,
+			Endpoint: r.flag.TracingEndpoint,
+			Insecure: r.flag.TracingInsecure,
+			IOWriter: r.stderr,
+
+			ServiceName:    project.Name(),
+			ServiceVersion: project.Version(),
+		}
+
+		if r.flag.TracingFile != "" {
+			f, err := os.Create(r.flag.TracingFile)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+			defer f.Close()
+
+			c.IOWriter = f
+		}
+
+		provider, err := tracing.New(context.Background(), c)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+
+			err := provider.Shutdown(ctx)
+			if err != nil {
+				r.logger.LogCtx(ctx, "level", "warning", "message", "failed to flush spans", "error", err)
+			}
+		}()
+
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			r.logger.Log("level", "warning", "message", "failed to export spans", "error", err)
+		}))
+
+		r.tracerProvider = provider
+	}
+	r.tracer = tracing.Tracer(r.tracerProvider, "github.com/giantswarm/crsync/cmd/sync")
+
+	// ctx is cancelled only when running jobs do not finish within the
+	// drain timeout after the process is asked to terminate.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	r.stopping = stopCtx.Done()
+
+	go func() {
+		<-stopCtx.Done()
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.logger.LogCtx(ctx, "level", "info", "message", "received termination signal, waiting for running jobs to finish", "drainTimeout", r.flag.DrainTimeout)
+
+		timer := time.NewTimer(r.flag.DrainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			r.logger.LogCtx(ctx, "level", "warning", "message", "running jobs did not finish within drain timeout, cancelling them", "drainTimeout", r.flag.DrainTimeout)
+			cancel()
+		}
+	}()
+
 	err = r.run(ctx, cmd, args)
+	if ctx.Err() != nil {
+		return microerror.Maskf(interruptedError, "running jobs were cancelled after drain timeout %s", r.flag.DrainTimeout)
+	}
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
+	if r.isStopping() {
+		r.logger.LogCtx(ctx, "level", "info", "message", "shut down gracefully")
+	}
+
 	return nil
 }
 
+// isStopping returns true when the process was asked to terminate.
+func (r *runner) isStopping() bool {
+	select {
+	case <-r.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
 func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) error {
 	var err error
 
-	fmt.Printf("Source registry       = %#q\n", sourceRegistryName)
-	fmt.Printf("Destination registry  = %#q\n", r.flag.DstRegistryName)
+	// Verification audits all the repositories unless the last modified
+	// window is given explicitly.
+	if r.verification != nil && !r.flag.flags.Changed(flagLastModified) {
+		r.flag.config.Source.LastModified = 0
+	}
+
+	r.logger.LogCtx(ctx, "level", "info", "message", "source registry", "registry", r.flag.config.Source.Name, "namespaces", strings.Join(r.flag.config.Source.Namespaces, ","))
+	for _, d := range r.flag.config.Destinations {
+		r.logger.LogCtx(ctx, "level", "info", "message", "destination registry", "registry", d.Name)
+	}
 
-	// Setup progress printer.
+	// Setup progress logging.
 	{
 		start := time.Now()
 		ticker := time.NewTicker(60 * time.Second)
@@ -88,11 +250,14 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 				case <-ctx.Done():
 					return
 				case <-ticker.C:
-					fmt.Printf(
-						"*** Progress: repositories: [%d/%d] tags: [%d/%d] time elapsed: %s ***\n",
-						r.progressReposDone, r.progressReposTotal,
-						r.progressTagsDone, r.progressTagsTotal,
-						time.Since(start).Round(time.Second),
+					r.logger.LogCtx(ctx,
+						"level", "info",
+						"message", "progress",
+						"repositoriesDone", atomic.LoadInt64(&r.progressReposDone),
+						"repositoriesTotal", atomic.LoadInt64(&r.progressReposTotal),
+						"tagsDone", atomic.LoadInt64(&r.progressTagsDone),
+						"tagsTotal", atomic.LoadInt64(&r.progressTagsTotal),
+						"elapsed", time.Since(start).Round(time.Second),
 					)
 				}
 			}
@@ -100,406 +265,1854 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		defer ticker.Stop()
 	}
 
-	var srcRegistryClient registry.RegistryClient
-	{
-		switch registryName := r.flag.SrcRegistryName; {
-		case registryName == "quay.io":
-			c := quay.Config{
-				Namespace:                  key.Namespace,
-				LastModified:               r.flag.LastModified,
-				Token:                      r.flag.QuayAPIToken,
-				IncludePrivateRepositories: r.flag.IncludePrivateRepositories,
-			}
-
-			srcRegistryClient, err = quay.New(c)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-
-		default:
-			return microerror.Maskf(executionFailedError, "unknown container registry %#q", registryName)
-		}
-
-	}
-
-	var srcRegistry registry.Interface
 	{
-		c := registry.Config{
-			Name:           sourceRegistryName,
-			RegistryClient: srcRegistryClient,
+		c := copier.Config{
+			TracerProvider: r.tracerProvider,
 		}
 
-		srcRegistry, err = registry.New(c)
+		r.copier, err = copier.New(c)
 		if err != nil {
 			return microerror.Mask(err)
 		}
+	}
 
-		srcRegistry, err = newDecoratedRegistry(srcRegistry)
-		if err != nil {
-			return microerror.Mask(err)
+	r.credentials = map[registry.Interface]registryCredentials{}
+	r.lastLoginAt = map[registry.Interface]time.Time{}
+	r.throttles = map[registry.Interface]*registry.Throttle{}
+	r.retries = map[registry.Interface]registry.RetryPolicy{}
+	r.webhooks = newWebhookQueue()
+
+	{
+		registries := []string{r.flag.config.Source.Name}
+		for _, d := range r.flag.config.Destinations {
+			registries = append(registries, d.Name)
 		}
+
+		r.status = newStatus(registries)
 	}
 
-	var dstRegistryClient registry.RegistryClient
 	{
-		switch registryName := r.flag.DstRegistryName; {
-		case registryName == "docker.io":
-			c := dockerhub.Config{}
+		var destinations []string
+		for _, d := range r.flag.config.Destinations {
+			destinations = append(destinations, d.Name)
+		}
 
-			dstRegistryClient, err = dockerhub.New(c)
-			if err != nil {
-				return microerror.Mask(err)
-			}
-		case strings.HasSuffix(registryName, "azurecr.io"):
-			c := azurecr.Config{
-				RegistryName: r.flag.DstRegistryName,
-			}
+		onSkip := func(key string, n int) {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "skipped scheduled runs because an iteration was running", "schedule", key, "runs", n)
+			skippedRunsTotal.WithLabelValues(key).Add(float64(n))
+		}
 
-			dstRegistryClient, err = azurecr.New(c)
-			if err != nil {
-				return microerror.Mask(err)
-			}
+		r.scheduler = newScheduler(r.flag.config.Schedule, r.flag.config.FullSync.Every, destinations, onSkip, time.Now())
+		scheduleMetrics.Set(r.scheduler)
+	}
 
-		default:
-			return microerror.Maskf(executionFailedError, "unknown container registry %#q", registryName)
+	r.state, err = r.newStateStore()
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer r.state.Close()
+	if r.flag.config.State.Path != "" && r.verification == nil {
+		if r.plan != nil {
+			r.logger.LogCtx(ctx, "level", "info", "message", "reading state file", "path", r.flag.config.State.Path)
+		} else {
+			r.logger.LogCtx(ctx, "level", "info", "message", "using state file", "path", r.flag.config.State.Path)
 		}
 	}
 
-	var dstRegistry registry.Interface
+	var srcRegistry registry.Interface
 	{
-		config := registry.Config{
-			Name:           r.flag.DstRegistryName,
-			RegistryClient: dstRegistryClient,
-		}
-
-		dstRegistry, err = registry.New(config)
+		srcRegistry, err = r.newSrcRegistry()
 		if err != nil {
 			return microerror.Mask(err)
 		}
 
-		dstRegistry, err = newDecoratedRegistry(dstRegistry)
-		if err != nil {
-			return microerror.Mask(err)
+		r.credentials[srcRegistry] = registryCredentials{
+			User:     r.flag.config.Source.Credentials.User,
+			Password: r.flag.config.Source.Credentials.Password.Value,
 		}
 	}
 
-	if !r.flag.Loop {
-		err := r.sync(ctx, srcRegistry, dstRegistry)
+	var dstRegistries []destination
+	for _, d := range r.flag.config.Destinations {
+		dstRegistry, err := r.newDstRegistry(d.Registry)
 		if err != nil {
 			return microerror.Mask(err)
 		}
 
-		return nil
-	}
-
-	if r.flag.MetricsPort != 0 {
-		go func() {
-			fmt.Printf("Serving metrics at :%d\n", r.flag.MetricsPort)
-			http.Handle("/metrics", promhttp.HandlerFor(
-				prometheus.DefaultGatherer,
-				promhttp.HandlerOpts{},
-			))
-			server := &http.Server{
-				Addr:              fmt.Sprintf(":%d", r.flag.MetricsPort),
-				ReadHeaderTimeout: 60 * time.Second,
-			}
-			err := server.ListenAndServe()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed serving metrics: %s", microerror.Pretty(microerror.Mask(err), true))
-			}
-		}()
-	} else {
-		fmt.Println("Metrics disabled")
-	}
+		r.credentials[dstRegistry] = registryCredentials{
+			User:     d.Credentials.User,
+			Password: d.Credentials.Password.Value,
+		}
 
-	for {
-		start := time.Now()
+		dstRegistries = append(dstRegistries, destination{
+			Registry: dstRegistry,
+			Mapping:  d.Mapping,
+			Mirror:   d.Mirror,
+		})
 
-		err := r.sync(ctx, srcRegistry, dstRegistry)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "\nSync error:\n%s\n\n", microerror.Pretty(microerror.Mask(err), true))
-			errorsTotal.Inc()
-		} else {
-			fmt.Printf("\nTook %s\n", time.Since(start))
+		if d.Mirror.Enabled {
+			r.logger.LogCtx(ctx, "level", "info", "message", "mirror mode", "registry", d.Name, "dryRun", d.Mirror.DryRun, "maxDeletions", d.Mirror.MaxDeletions, "maxDeletionsPercent", *d.Mirror.MaxDeletionsPercent, "gracePeriod", d.Mirror.GracePeriod)
 		}
-
-		time.Sleep(time.Duration(r.flag.SyncInterval) * time.Second)
 	}
-}
-
-func (r *runner) sync(ctx context.Context, srcRegistry, dstRegistry registry.Interface) error {
-	var err error
 
-	fmt.Println()
+	r.logger.LogCtx(ctx, "level", "info", "message", "workers", "repositories", r.flag.config.Workers.Repositories, "tags", r.flag.config.Workers.Tags)
+	r.logLimits(ctx, r.flag.config.Source.Registry)
+	for _, d := range r.flag.config.Destinations {
+		r.logLimits(ctx, d.Registry)
+	}
 
-	if r.lastLoginAt == nil {
-		fmt.Printf("Logging in source registry...\n")
-		err = srcRegistry.Login(ctx, r.flag.SrcRegistryUser, r.flag.SrcRegistryPassword)
+	if !r.flag.Loop {
+		err := r.sync(ctx, srcRegistry, dstRegistries, nil, r.scheduler.Start(scheduleIncremental, time.Now()))
 		if err != nil {
 			return microerror.Mask(err)
 		}
 
-		fmt.Printf("Logging in destination registry...\n")
-		err = dstRegistry.Login(ctx, r.flag.DstRegistryUser, r.flag.DstRegistryPassword)
-		if err != nil {
-			return microerror.Mask(err)
+		if r.plan != nil {
+			err = r.writeReport(r.plan)
+			if err != nil {
+				return microerror.Mask(err)
+			}
 		}
-		timeNow := time.Now()
-		r.lastLoginAt = &timeNow
-	} else {
-		fmt.Printf("Already logged in\n")
-	}
 
-	defer func(ctx context.Context) {
-		fmt.Println()
-		if r.lastLoginAt != nil && time.Since(*r.lastLoginAt) >= loginTTL {
-			fmt.Printf("Logging out of source registry...\n")
-			_ = srcRegistry.Logout(ctx)
-			fmt.Printf("Logging out of destination registry...\n")
-			_ = dstRegistry.Logout(ctx)
-			r.lastLoginAt = nil
+		if r.verification != nil {
+			err = r.writeReport(r.verification)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+
+			if r.verification.Failed() {
+				s := r.verification.Summary()
+				return microerror.Maskf(verificationFailedError, "found %d missing, %d mismatched and %d tags with missing blobs, %d errors", s.Missing, s.Mismatch, s.MissingBlobs, s.Errors)
+			}
 		}
-	}(ctx)
 
-	// getTagsJobCh channel has buffer 4 times bigger listing tags/repos burst to not starve
-	// processing.
-	getTagsJobCh := make(chan getTagsJob, listBurst*4)
-	getTagsWG := sync.WaitGroup{}
+		return nil
+	}
 
-	// retagJobCh channel has buffer 2 times bigger push/pull burst to not starve
-	// processing.
-	retagJobCh := make(chan retagJob, pullPushBurst*2)
-	retagWG := sync.WaitGroup{}
+	// Probes are served on their own port so they work with metrics
+	// disabled. They share the server when both ports are the same.
+	healthMux := http.NewServeMux()
+	healthMux.HandleFunc("/healthz", r.serveHealthz)
+	healthMux.HandleFunc("/readyz", r.serveReadyz)
+	healthMux.HandleFunc("/status", r.serveStatus)
 
-	for i := 0; i < getTagsWorkersNum; i++ {
-		getTagsWG.Add(1)
-		go func(ctx context.Context) {
-			defer getTagsWG.Done()
-			r.processGetTagsJobs(ctx, getTagsJobCh, retagJobCh)
-		}(ctx)
-	}
-	for i := 0; i < retagWorkesNum; i++ {
-		retagWG.Add(1)
-		go func(ctx context.Context) {
-			defer retagWG.Done()
-			r.processRetagJobs(ctx, retagJobCh)
-		}(ctx)
-	}
+	if r.flag.MetricsPort != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(
+			prometheus.DefaultGatherer,
+			promhttp.HandlerOpts{},
+		))
+		mux.HandleFunc("/state", r.serveState)
+		if r.flag.HealthPort == r.flag.MetricsPort {
+			mux.Handle("/", healthMux)
+		}
 
-	fmt.Println()
-	fmt.Printf("Reading list of repositories to sync from source registry...\n")
-	reposToSync, err := srcRegistry.ListRepositories(ctx)
-	if err != nil {
-		return microerror.Mask(err)
+		go r.serve(ctx, "metrics", r.flag.MetricsPort, mux)
+	} else {
+		r.logger.LogCtx(ctx, "level", "info", "message", "metrics disabled")
 	}
-	r.progressReposTotal = int64(len(reposToSync))
 
-	fmt.Printf("There are %d repositories to sync.\n", r.progressReposTotal)
-	if r.progressReposTotal > 0 {
-		fmt.Println()
+	if r.flag.HealthPort != r.flag.MetricsPort {
+		go r.serve(ctx, "health probes", r.flag.HealthPort, healthMux)
 	}
 
-	for repoIndex, repo := range reposToSync {
-		job := getTagsJob{
-			Src: srcRegistry,
-			Dst: dstRegistry,
+	if port := r.flag.config.Webhook.Port; port != 0 {
+		go func() {
+			r.logger.LogCtx(ctx, "level", "info", "message", "receiving webhooks", "port", port, "path", webhookPath)
+			mux := http.NewServeMux()
+			mux.HandleFunc(webhookPath, r.serveWebhook)
+			server := &http.Server{
+				Addr:              fmt.Sprintf(":%d", port),
+				Handler:           mux,
+				ReadHeaderTimeout: 60 * time.Second,
+			}
+			err := server.ListenAndServe()
+			if err != nil {
+				r.logger.LogCtx(ctx, "level", "error", "message", "failed receiving webhooks", "error", err)
+			}
+		}()
+	}
 
-			ID:   fmt.Sprintf("Repository [%d/%d] = %#q", repoIndex+1, r.progressReposTotal, repo),
-			Repo: repo,
+	for !r.isStopping() {
+		key, at := r.scheduler.Next()
+		if d := time.Until(at); d > 0 {
+			r.logger.LogCtx(ctx, "level", "debug", "message", "waiting for next scheduled run", "schedule", key, "at", at.UTC().Format(time.RFC3339))
+			r.wait(ctx, srcRegistry, dstRegistries, d)
 		}
-
-		select {
-		case <-ctx.Done():
-			return microerror.Mask(ctx.Err())
-		case getTagsJobCh <- job:
-			// Job added.
+		if r.isStopping() {
+			break
 		}
-	}
 
-	// Wat for getting tags to finish.
-	close(getTagsJobCh)
-	getTagsWG.Wait()
-	// Wat for retagging to finish.
-	close(retagJobCh)
-	retagWG.Wait()
+		trigger := r.scheduler.Start(key, time.Now())
+		r.syncLogged(ctx, srcRegistry, dstRegistries, nil, trigger)
+		r.scheduler.Finish(key, trigger, time.Now())
+	}
 
 	return nil
 }
 
-func (r *runner) processGetTagsJobs(ctx context.Context, jobCh <-chan getTagsJob, resultCh chan retagJob) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case job, ok := <-jobCh:
-			if !ok {
-				return
-			}
-
-			start := time.Now()
-
-			fmt.Printf("%s: Getting list of tags to sync...\n", job.ID)
-
-			tags, err := r.processGetTagsJob(ctx, job)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s: Failed to get list of tags to sync: %s\n", job.ID, microerror.Pretty(microerror.Mask(err), true))
-				errorsTotal.Inc()
-				continue
-			}
-
-			_ = atomic.AddInt64(&r.progressTagsTotal, int64(len(tags)))
-
-			fmt.Printf("%s: Scheduling %d tags to sync...\n", job.ID, len(tags))
-
-			for i, t := range tags {
-				j := retagJob{
-					Src: job.Src,
-					Dst: job.Dst,
+// syncLogged runs sync and reports its error instead of returning it.
+// Iterations syncing all the repositories are incremental and full syncs
+// started by the scheduler. Repositories given explicitly were pushed and
+// queued by webhooks.
+func (r *runner) syncLogged(ctx context.Context, srcRegistry registry.Interface, dstRegistries []destination, repos []string, trigger string) {
+	start := time.Now()
+
+	err := r.sync(ctx, srcRegistry, dstRegistries, repos, trigger)
+	if IsInterrupted(err) {
+		r.logger.LogCtx(ctx, "level", "info", "message", "sync interrupted", "trigger", trigger, "duration", time.Since(start).Round(time.Millisecond))
+		return
+	}
 
-					ID:   fmt.Sprintf("%s: Tag [%d/%d] = %#q", job.ID, i+1, len(tags), t),
-					Repo: job.Repo,
-					Tag:  t,
-				}
+	iterationDuration.WithLabelValues(trigger).Observe(time.Since(start).Seconds())
 
-				select {
-				case <-ctx.Done():
-					fmt.Fprintf(os.Stderr, "%s: Cancelled while scheduling %d/%d job: %s ***\n", job.ID, i+1, len(tags), microerror.Pretty(microerror.Mask(err), true))
-					errorsTotal.Inc()
-				case resultCh <- j:
-					// ok
-				}
-			}
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "sync failed", "trigger", trigger, "duration", time.Since(start).Round(time.Millisecond), "error", err)
+		errorsTotal.WithLabelValues(srcRegistry.Name()).Inc()
+		return
+	}
 
-			fmt.Printf("%s: Done (took %s)\n", job.ID, time.Since(start).Round(time.Second))
-			_ = atomic.AddInt64(&r.progressReposDone, 1)
-		}
+	r.logger.LogCtx(ctx, "level", "info", "message", "sync completed", "trigger", trigger, "duration", time.Since(start).Round(time.Millisecond))
+	if trigger == triggerIncremental || trigger == triggerFull {
+		lastSuccessTimestamp.SetToCurrentTime()
+	}
+	if trigger == triggerFull {
+		lastFullSuccessTimestamp.SetToCurrentTime()
 	}
 }
 
-func (r *runner) processRetagJobs(ctx context.Context, jobCh <-chan retagJob) {
+// wait waits until the next scheduled run. Repositories queued by webhooks in
+// the meantime are synced immediately.
+func (r *runner) wait(ctx context.Context, srcRegistry registry.Interface, dstRegistries []destination, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case job, ok := <-jobCh:
-			if !ok {
-				return
-			}
-
-			start := time.Now()
-
-			fmt.Printf("%s: Retagging...\n", job.ID)
-
-			err := r.processRetagJob(ctx, job)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s: Failed to retag: %s\n", job.ID, microerror.Pretty(microerror.Mask(err), true))
-				errorsTotal.Inc()
+		case <-r.stopping:
+			return
+		case <-timer.C:
+			return
+		case <-r.webhooks.Ready():
+			repos := r.webhooks.Drain()
+			if len(repos) == 0 {
 				continue
 			}
 
-			fmt.Printf("%s: Done (took %s)\n", job.ID, time.Since(start).Round(time.Second))
-			_ = atomic.AddInt64(&r.progressTagsDone, 1)
+			r.syncLogged(ctx, srcRegistry, dstRegistries, repos, triggerWebhook)
 		}
 	}
 }
 
-func (r *runner) processGetTagsJob(ctx context.Context, job getTagsJob) ([]string, error) {
-	var srcTags, dstTags []string
+// serve serves the handler on the port until the process exits.
+func (r *runner) serve(ctx context.Context, name string, port int, handler http.Handler) {
+	r.logger.LogCtx(ctx, "level", "info", "message", fmt.Sprintf("serving %s", name), "port", port)
 
-	eg := new(errgroup.Group)
-	eg.Go(func() error {
-		var err error
-		srcTags, err = job.Src.ListTags(ctx, job.Repo)
-		if err == nil {
-			tagsTotal.WithLabelValues(job.Src.Name(), job.Repo).Set(float64(len(srcTags)))
-		}
-		return microerror.Mask(err)
-	})
-	eg.Go(func() error {
-		var err error
-		dstTags, err = job.Dst.ListTags(ctx, job.Repo)
-		if err == nil {
-			tagsTotal.WithLabelValues(job.Dst.Name(), job.Repo).Set(float64(len(dstTags)))
-		}
-		return microerror.Mask(err)
-	})
-	err := eg.Wait()
-	if err != nil {
-		return nil, microerror.Mask(err)
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: 60 * time.Second,
 	}
-
-	tags := sliceDiff(srcTags, dstTags)
-
-	return tags, nil
-}
-
-func (r *runner) processRetagJob(ctx context.Context, job retagJob) error {
-	err := job.Src.Pull(ctx, job.Repo, job.Tag)
+	err := server.ListenAndServe()
 	if err != nil {
-		return microerror.Mask(err)
+		r.logger.LogCtx(ctx, "level", "error", "message", fmt.Sprintf("failed serving %s", name), "error", err)
 	}
+}
 
-	err = registry.RetagImage(job.Repo, job.Tag, sourceRegistryName, r.flag.DstRegistryName)
-	if err != nil {
-		// Try to remove the image by best effort in case of error.
-		_ = job.Src.RemoveImage(ctx, job.Repo, job.Tag)
-		return microerror.Mask(err)
-	}
+// writeReport writes the plan or verification report to the --output-file
+// or to the standard output.
+func (r *runner) writeReport(report interface {
+	Write(w io.Writer, output string) error
+}) error {
+	w := r.stdout
+	if r.flag.OutputFile != "" {
+		f, err := os.Create(r.flag.OutputFile)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		defer f.Close()
 
-	err = job.Src.RemoveImage(ctx, job.Repo, job.Tag)
-	if err != nil {
-		return microerror.Mask(err)
+		w = f
+	} else {
+		fmt.Fprintln(w)
 	}
 
-	err = job.Dst.Push(ctx, job.Repo, job.Tag)
+	err := report.Write(w, r.flag.Output)
 	if err != nil {
-		// Try to remove the image by best effort in case of error.
-		_ = job.Dst.RemoveImage(ctx, job.Repo, job.Tag)
 		return microerror.Mask(err)
 	}
 
-	err = job.Dst.RemoveImage(ctx, job.Repo, job.Tag)
-	if err != nil {
-		return microerror.Mask(err)
+	if r.flag.OutputFile != "" {
+		r.logger.Log("level", "info", "message", "report written", "path", r.flag.OutputFile)
 	}
 
 	return nil
 }
 
-func newDecoratedRegistry(reg registry.Interface) (*registry.DecoratedRegistry, error) {
-	c := registry.DecoratedRegistryConfig{
-		RateLimiter: registry.DecoratedRegistryConfigRateLimiter{
-			ListRepositories: rate.NewLimiter(rate.Every(5*time.Second), listBurst),
-			ListTags:         rate.NewLimiter(rate.Every(1*time.Second), listBurst),
-			Pull:             rate.NewLimiter(rate.Every(1*time.Second), pullPushBurst),
-			Push:             rate.NewLimiter(rate.Every(1*time.Second), pullPushBurst),
-		},
-		Underlying: reg,
-	}
+func (r *runner) newSrcRegistry() (registry.Interface, error) {
+	var err error
+
+	source := r.flag.config.Source
 
-	r, err := registry.NewDecoratedRegistry(c)
+	throttle, err := r.newThrottle(source.Name)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
+	httpClient := &http.Client{
+		Transport: throttle.RoundTripper(newTransport(source.Insecure)),
+	}
 
-	return r, nil
-}
-
-func sliceDiff(s1, s2 []string) []string {
-	var result []string
+	var registryClient registry.RegistryClient
+	{
+		switch registryName := source.Name; {
+		case registryName == quayRegistryName:
+			c := quay.Config{
+				Logger:                     r.logger,
+				Namespaces:                 source.Namespaces,
+				LastModified:               source.LastModified,
+				Token:                      source.QuayAPIToken.Value,
+				IncludePrivateRepositories: source.IncludePrivateRepositories,
+				HTTPClient:                 httpClient,
+			}
+
+			registryClient, err = quay.New(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+		case registryName == dockerHubRegistryName:
+			c := dockerhub.Config{
+				Logger:                     r.logger,
+				Namespaces:                 source.Namespaces,
+				LastModified:               source.LastModified,
+				IncludePrivateRepositories: source.IncludePrivateRepositories,
+				HTTPClient:                 httpClient,
+			}
+
+			registryClient, err = dockerhub.New(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+		case strings.HasSuffix(registryName, "azurecr.io"):
+			c := azurecr.Config{
+				Logger:       r.logger,
+				RegistryName: registryName,
+				Namespaces:   source.Namespaces,
+				HTTPClient:   httpClient,
+			}
+
+			registryClient, err = azurecr.New(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+
+		default:
+			c := distribution.Config{
+				RegistryName: registryName,
+				Namespaces:   source.Namespaces,
+				Insecure:     source.Insecure,
+				HTTPClient:   httpClient,
+			}
+
+			registryClient, err = distribution.New(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+		}
+	}
+
+	quotaProbe, err := newQuotaProbe(source.Name, httpClient)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var srcRegistry registry.Interface
+	{
+		c := registry.Config{
+			Name:           source.Name,
+			RegistryClient: registryClient,
+			Insecure:       source.Insecure,
+			QuotaProbe:     quotaProbe,
+		}
+
+		srcRegistry, err = registry.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		srcRegistry, err = r.newDecoratedRegistry(srcRegistry, source.Limits, throttle)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		retry := r.newRetryPolicy(source.Name, source.Retry)
+		srcRegistry, err = newRetryingRegistry(srcRegistry, retry)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		r.throttles[srcRegistry] = throttle
+		r.retries[srcRegistry] = retry
+	}
+
+	return srcRegistry, nil
+}
+
+func (r *runner) newDstRegistry(destination config.Registry) (registry.Interface, error) {
+	var err error
+
+	throttle, err := r.newThrottle(destination.Name)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	httpClient := &http.Client{
+		Transport: throttle.RoundTripper(newTransport(destination.Insecure)),
+	}
+
+	var registryClient registry.RegistryClient
+	{
+		switch registryName := destination.Name; {
+		case registryName == dockerHubRegistryName:
+			c := dockerhub.Config{
+				Logger:     r.logger,
+				HTTPClient: httpClient,
+			}
+
+			registryClient, err = dockerhub.New(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+		case strings.HasSuffix(registryName, "azurecr.io"):
+			c := azurecr.Config{
+				Logger:       r.logger,
+				RegistryName: registryName,
+				HTTPClient:   httpClient,
+			}
+
+			registryClient, err = azurecr.New(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+
+		default:
+			c := distribution.Config{
+				RegistryName: registryName,
+				Insecure:     destination.Insecure,
+				HTTPClient:   httpClient,
+			}
+
+			registryClient, err = distribution.New(c)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+		}
+	}
+
+	var dstRegistry registry.Interface
+	{
+		c := registry.Config{
+			Name:           destination.Name,
+			RegistryClient: registryClient,
+			Insecure:       destination.Insecure,
+		}
+
+		dstRegistry, err = registry.New(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		dstRegistry, err = r.newDecoratedRegistry(dstRegistry, destination.Limits, throttle)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		retry := r.newRetryPolicy(destination.Name, destination.Retry)
+		dstRegistry, err = newRetryingRegistry(dstRegistry, retry)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		r.throttles[dstRegistry] = throttle
+		r.retries[dstRegistry] = retry
+	}
+
+	return dstRegistry, nil
+}
+
+// sync syncs the given source repositories or all the repositories listed
+// from the source registry when repos is nil. Repositories queued by
+// webhooks are synced before the other ones. Repositories which last sync
+// failed are retried even when they were not modified recently. Full syncs
+// list all the repositories and compare all their tags with destinations.
+func (r *runner) sync(ctx context.Context, srcRegistry registry.Interface, dstRegistries []destination, repos []string, trigger string) (err error) {
+	full := trigger == triggerFull
+
+	ctx, span := r.tracer.Start(ctx, "sync", trace.WithAttributes(attribute.String("trigger", trigger)))
+
+	atomic.StoreInt64(&r.progressReposDone, 0)
+	atomic.StoreInt64(&r.progressReposTotal, 0)
+	atomic.StoreInt64(&r.progressTagsDone, 0)
+	atomic.StoreInt64(&r.progressTagsTotal, 0)
+	r.status.StartIteration(trigger)
+
+	defer func() {
+		r.status.FinishIteration(r.progress(), err)
+		tracing.End(span, err)
+	}()
+
+	r.quietMutex.Lock()
+	r.quietLeftOut = map[string]bool{}
+	r.quietMutex.Unlock()
+
+	dstRegistries = r.leaveOutQuiet(ctx, dstRegistries, time.Now())
+	if len(dstRegistries) == 0 {
+		r.logger.LogCtx(ctx, "level", "info", "message", "all destination registries are in quiet windows, skipping", "trigger", trigger)
+		return nil
+	}
+
+	err = r.login(ctx, srcRegistry)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	// Destinations failing to log in are skipped in this iteration so they
+	// do not block syncing to the other ones.
+	var dsts []destination
+	for _, dst := range dstRegistries {
+		err = r.login(ctx, dst.Registry)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "error", "message", "failed to log in destination registry", "registry", dst.Registry.Name(), "error", err)
+			errorsTotal.WithLabelValues(dst.Registry.Name()).Inc()
+			continue
+		}
+
+		dsts = append(dsts, dst)
+	}
+	if len(dsts) == 0 {
+		return microerror.Maskf(executionFailedError, "failed to log in all destination registries")
+	}
+
+	r.deletionsLeft = map[registry.Interface]*int64{}
+	r.deletionsDone = map[registry.Interface]*int64{}
+	for _, dst := range dsts {
+		left := int64(dst.Mirror.MaxDeletions)
+		r.deletionsLeft[dst.Registry] = &left
+		r.deletionsDone[dst.Registry] = new(int64)
+	}
+
+	defer r.logoutExpired(ctx)
+
+	limits := r.flag.config.Source.Limits
+	workers := r.flag.config.Workers
+
+	// getTagsJobCh channel has buffer 4 times bigger listing tags/repos burst to not starve
+	// processing.
+	getTagsJobCh := make(chan getTagsJob, limits.ListTags.Burst*4)
+	getTagsWG := sync.WaitGroup{}
+
+	// retagJobCh channel has buffer 2 times bigger push/pull burst to not starve
+	// processing.
+	retagJobCh := make(chan retagJob, limits.Pull.Burst*2)
+	retagWG := sync.WaitGroup{}
+
+	for i := 0; i < workers.Repositories; i++ {
+		getTagsWG.Add(1)
+		go func(ctx context.Context) {
+			defer getTagsWG.Done()
+			r.processGetTagsJobs(ctx, getTagsJobCh, retagJobCh)
+		}(ctx)
+	}
+	for i := 0; i < workers.Tags; i++ {
+		retagWG.Add(1)
+		go func(ctx context.Context) {
+			defer retagWG.Done()
+			r.processRetagJobs(ctx, retagJobCh)
+		}(ctx)
+	}
+
+	// Workers are stopped also when the iteration fails early so failed
+	// iterations do not leak them.
+	var stopWorkersOnce sync.Once
+	stopWorkers := func() {
+		stopWorkersOnce.Do(func() {
+			// Wait for getting tags to finish.
+			close(getTagsJobCh)
+			getTagsWG.Wait()
+			// Wait for retagging to finish.
+			close(retagJobCh)
+			retagWG.Wait()
+		})
+	}
+	defer stopWorkers()
+
+	// modified holds when repositories listed in this iteration were last
+	// modified. Repositories queued by webhooks or retried after failures
+	// are not in it because they may have changed since they were listed.
+	modified := map[string]time.Time{}
+	if repos == nil {
+		listCtx := ctx
+		if full {
+			listCtx = registry.WithAllRepositories(ctx)
+		}
+
+		r.logger.LogCtx(ctx, "level", "info", "message", "listing repositories to sync", "registry", srcRegistry.Name(), "trigger", trigger)
+		repos, err = srcRegistry.ListRepositories(listCtx)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		for _, repo := range repos {
+			if t, ok := srcRegistry.LastModified(repo); ok {
+				modified[repo] = t
+			}
+		}
+
+		failed := r.failedRepositories(ctx, srcRegistry.Name(), repos)
+		if len(failed) > 0 && !full {
+			r.logger.LogCtx(ctx, "level", "info", "message", "retrying failed repositories", "registry", srcRegistry.Name(), "repositories", len(failed))
+			repos = append(repos, failed...)
+		}
+	}
+
+	var reposToSync []string
+	for _, repo := range repos {
+		if r.flag.config.Repositories.Selects(repo) {
+			reposToSync = append(reposToSync, repo)
+		}
+	}
+	atomic.StoreInt64(&r.progressReposTotal, int64(len(reposToSync)))
+	span.SetAttributes(attribute.Int("repositories", len(reposToSync)))
+
+	r.logger.LogCtx(ctx, "level", "info", "message", "found repositories to sync", "registry", srcRegistry.Name(), "repositories", atomic.LoadInt64(&r.progressReposTotal))
+
+	repoDsts := r.repositoryDestinations(ctx, reposToSync, dsts)
+
+	// Every repository is synced once in the iteration even when it is
+	// also queued by a webhook. Repositories are claimed by the loop below
+	// and by the goroutine scheduling repositories queued by webhooks.
+	var scheduledMutex sync.Mutex
+	scheduled := map[string]bool{}
+	claim := func(repo string) bool {
+		scheduledMutex.Lock()
+		defer scheduledMutex.Unlock()
+
+		if scheduled[repo] {
+			return false
+		}
+		scheduled[repo] = true
+
+		return true
+	}
+	schedule := func(repo string, repoDst []destination, lastModified time.Time) error {
+		job := getTagsJob{
+			Src:  srcRegistry,
+			Dsts: repoDst,
+
+			Repo:         repo,
+			Full:         full,
+			LastModified: lastModified,
+		}
+
+		select {
+		case <-ctx.Done():
+			return microerror.Mask(ctx.Err())
+		case <-r.stopping:
+			// Not scheduling any more jobs.
+		case getTagsJobCh <- job:
+			queueDepth.WithLabelValues(queueGetTags).Set(float64(len(getTagsJobCh)))
+		}
+
+		return nil
+	}
+	scheduleQueued := func() error {
+		for _, queued := range r.webhooks.Drain() {
+			if !claim(queued) {
+				continue
+			}
+			_ = atomic.AddInt64(&r.progressReposTotal, 1)
+
+			repoDst, ok := repoDsts[queued]
+			if !ok {
+				repoDst = r.repositoryDestinations(ctx, []string{queued}, dsts)[queued]
+			}
+			repoDst = r.leaveOutQuiet(ctx, repoDst, time.Now())
+			if len(repoDst) == 0 {
+				_ = atomic.AddInt64(&r.progressReposDone, 1)
+				continue
+			}
+
+			r.logger.LogCtx(ctx, "level", "debug", "message", "scheduling repository queued by webhook", "registry", srcRegistry.Name(), "repository", queued)
+
+			err := schedule(queued, repoDst, time.Time{})
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+
+		return nil
+	}
+
+	// Repositories queued before the iteration are synced first.
+	err = scheduleQueued()
+	if err != nil {
+		return microerror.Mask(err)
+	}
 
-	for _, e1 := range s1 {
-		found := false
-		for _, e2 := range s2 {
-			if e1 == e2 {
-				found = true
-				break
+	// Repositories pushed while the iteration runs are scheduled as soon
+	// as they are queued instead of waiting for the next iteration or for
+	// the loop below to get to them.
+	webhooksDone := make(chan struct{})
+	webhooksWG := sync.WaitGroup{}
+	webhooksWG.Add(1)
+	go func() {
+		defer webhooksWG.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopping:
+				return
+			case <-webhooksDone:
+				return
+			case <-r.webhooks.Ready():
+				err := scheduleQueued()
+				if err != nil {
+					return
+				}
 			}
 		}
-		if !found {
-			result = append(result, e1)
+	}()
+	var stopWebhooksOnce sync.Once
+	stopWebhooks := func() {
+		stopWebhooksOnce.Do(func() {
+			close(webhooksDone)
+			webhooksWG.Wait()
+		})
+	}
+	defer stopWebhooks()
+
+	for _, repo := range reposToSync {
+		if r.isStopping() {
+			break
+		}
+
+		// Quiet windows opening while the iteration runs leave their
+		// destinations out of the remaining repositories.
+		repoDst := r.leaveOutQuiet(ctx, repoDsts[repo], time.Now())
+		if len(repoDst) == 0 || !claim(repo) {
+			_ = atomic.AddInt64(&r.progressReposDone, 1)
+			continue
+		}
+
+		err = schedule(repo, repoDst, modified[repo])
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	// Repositories queued after this point are synced by the next
+	// iteration or while waiting for it.
+	stopWebhooks()
+
+	stopWorkers()
+
+	if r.isStopping() {
+		return microerror.Maskf(interruptedError, "sync was interrupted by termination signal")
+	}
+
+	for _, dst := range dsts {
+		if !dst.Mirror.Enabled || r.plan != nil || r.verification != nil {
+			continue
+		}
+
+		done := atomic.LoadInt64(r.deletionsDone[dst.Registry])
+		if dst.Mirror.DryRun {
+			r.logger.LogCtx(ctx, "level", "info", "message", "would delete tags (dry run)", "registry", dst.Registry.Name(), "tags", done)
+		} else {
+			r.logger.LogCtx(ctx, "level", "info", "message", "deleted tags", "registry", dst.Registry.Name(), "tags", done)
+		}
+	}
+
+	return nil
+}
+
+// repositoryDestinations returns destinations of every repository. When
+// multiple repositories are mapped to the same destination repository only
+// the first one is synced there and the collision is reported. Repositories
+// without any destination are mapped to nil.
+func (r *runner) repositoryDestinations(ctx context.Context, repos []string, dsts []destination) map[string][]destination {
+	result := map[string][]destination{}
+	for _, repo := range repos {
+		result[repo] = nil
+	}
+
+	for _, dst := range dsts {
+		mapped := map[string]string{}
+		for _, repo := range repos {
+			dstRepo := dst.Mapping.Repository(repo)
+			if other, ok := mapped[dstRepo]; ok {
+				r.logger.LogCtx(ctx, "level", "error", "message", "repositories are mapped to the same destination repository, skipping", "registry", dst.Registry.Name(), "repository", dstRepo, "sourceRepository", repo, "otherSourceRepository", other)
+				errorsTotal.WithLabelValues(dst.Registry.Name()).Inc()
+				continue
+			}
+			mapped[dstRepo] = repo
+
+			result[repo] = append(result[repo], dst)
 		}
 	}
 
 	return result
 }
+
+func (r *runner) login(ctx context.Context, reg registry.Interface) error {
+	if _, ok := r.lastLoginAt[reg]; ok {
+		r.logger.LogCtx(ctx, "level", "debug", "message", "already logged in", "registry", reg.Name())
+		return nil
+	}
+
+	r.logger.LogCtx(ctx, "level", "info", "message", "logging in", "registry", reg.Name())
+
+	c := r.credentials[reg]
+	err := reg.Login(ctx, c.User, c.Password)
+	r.status.LoggedIn(reg.Name(), err == nil)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	r.lastLoginAt[reg] = time.Now()
+
+	return nil
+}
+
+func (r *runner) logoutExpired(ctx context.Context) {
+	for reg, lastLoginAt := range r.lastLoginAt {
+		if time.Since(lastLoginAt) < loginTTL {
+			continue
+		}
+
+		r.logger.LogCtx(ctx, "level", "info", "message", "logging out", "registry", reg.Name())
+		_ = reg.Logout(ctx)
+		delete(r.lastLoginAt, reg)
+	}
+}
+
+func (r *runner) processGetTagsJobs(ctx context.Context, jobCh <-chan getTagsJob, resultCh chan retagJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-jobCh:
+			if !ok {
+				return
+			}
+			queueDepth.WithLabelValues(queueGetTags).Set(float64(len(jobCh)))
+			if r.isStopping() {
+				continue
+			}
+
+			start := time.Now()
+
+			ctx, span := r.tracer.Start(ctx, "sync.compareTags", trace.WithAttributes(
+				attribute.String("registry", job.Src.Name()),
+				attribute.String("repository", job.Repo),
+				attribute.Int("destinations", len(job.Dsts)),
+			))
+
+			r.logger.LogCtx(ctx, "level", "debug", "message", "listing tags to sync", "registry", job.Src.Name(), "repository", job.Repo)
+
+			done := r.status.StartJob(statusJob{
+				Kind:       jobCompareTags,
+				Registry:   job.Src.Name(),
+				Repository: job.Repo,
+			})
+
+			jobs, err := r.processGetTagsJob(ctx, job)
+			done()
+			span.SetAttributes(attribute.Int("tags", len(jobs)))
+			tracing.End(span, err)
+			if err != nil {
+				r.logger.LogCtx(ctx, "level", "error", "message", "failed to list tags to sync", "registry", job.Src.Name(), "repository", job.Repo, "error", err)
+				errorsTotal.WithLabelValues(job.Src.Name()).Inc()
+				r.recordRepository(ctx, &repositorySync{
+					err: err,
+					repository: state.Repository{
+						Registry:   job.Src.Name(),
+						Repository: job.Repo,
+					},
+				})
+				if r.verification != nil {
+					r.verification.Add(verifyEntry{
+						SourceRepository: job.Repo,
+						Status:           verifyStatusError,
+						Error:            err.Error(),
+					})
+				}
+				continue
+			}
+
+			_ = atomic.AddInt64(&r.progressTagsTotal, int64(len(jobs)))
+
+			if len(jobs) > 0 {
+				r.logger.LogCtx(ctx, "level", "info", "message", "scheduling tags to sync", "registry", job.Src.Name(), "repository", job.Repo, "tags", len(jobs))
+			}
+
+			for _, j := range jobs {
+				select {
+				case <-ctx.Done():
+					r.logger.LogCtx(ctx, "level", "error", "message", "cancelled while scheduling tag", "registry", job.Src.Name(), "repository", job.Repo, "tag", j.Tag, "error", ctx.Err())
+					errorsTotal.WithLabelValues(job.Src.Name()).Inc()
+				case <-r.stopping:
+					// Not scheduling any more jobs.
+				case resultCh <- j:
+					queueDepth.WithLabelValues(queueRetag).Set(float64(len(resultCh)))
+				}
+			}
+
+			r.logger.LogCtx(ctx, "level", "debug", "message", "listed tags to sync", "registry", job.Src.Name(), "repository", job.Repo, "tags", len(jobs), "duration", time.Since(start).Round(time.Millisecond))
+			_ = atomic.AddInt64(&r.progressReposDone, 1)
+		}
+	}
+}
+
+func (r *runner) processRetagJobs(ctx context.Context, jobCh <-chan retagJob) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-jobCh:
+			if !ok {
+				return
+			}
+			queueDepth.WithLabelValues(queueRetag).Set(float64(len(jobCh)))
+			if r.isStopping() {
+				continue
+			}
+
+			ctx, span := r.tracer.Start(ctx, "sync.copyTag", trace.WithAttributes(
+				attribute.String("registry", job.Src.Name()),
+				attribute.String("repository", job.Repo),
+				attribute.String("tag", job.Tag),
+				attribute.String("digest", job.Digest.String()),
+				attribute.Int("destinations", len(job.Dsts)),
+			))
+
+			done := r.status.StartJob(statusJob{
+				Kind:       jobCopyTag,
+				Registry:   job.Src.Name(),
+				Repository: job.Repo,
+				Tag:        job.Tag,
+			})
+
+			if r.plan != nil {
+				r.planRetagJob(ctx, job)
+				done()
+				span.End()
+				_ = atomic.AddInt64(&r.progressTagsDone, 1)
+				continue
+			}
+
+			start := time.Now()
+
+			r.logger.LogCtx(ctx, "level", "debug", "message", "copying tag", "registry", job.Src.Name(), "repository", job.Repo, "tag", job.Tag, "digest", job.Digest, "destinations", len(job.Dsts))
+
+			errs := r.processRetagJob(ctx, job)
+			done()
+			tracing.End(span, errors.Join(errs...))
+			for i, err := range errs {
+				dst := job.Dsts[i]
+				if err != nil {
+					r.logger.LogCtx(ctx, "level", "error", "message", "failed to copy tag", "registry", dst.Registry.Name(), "repository", dst.Repo, "tag", dst.Tag, "sourceRepository", job.Repo, "sourceTag", job.Tag, "digest", job.Digest, "duration", time.Since(start).Round(time.Millisecond), "error", err)
+					errorsTotal.WithLabelValues(dst.Registry.Name()).Inc()
+					continue
+				}
+				r.logger.LogCtx(ctx, "level", "info", "message", "copied tag", "registry", dst.Registry.Name(), "repository", dst.Repo, "tag", dst.Tag, "sourceRepository", job.Repo, "sourceTag", job.Tag, "digest", job.Digest, "duration", time.Since(start).Round(time.Millisecond))
+				syncedTagsTotal.WithLabelValues(dst.Registry.Name(), dst.Repo).Inc()
+			}
+			r.recordRetagJob(ctx, job, errs)
+			_ = atomic.AddInt64(&r.progressTagsDone, 1)
+		}
+	}
+}
+
+// processGetTagsJob lists tags of the repository in the source registry once
+// and compares them with every destination. It returns a job for every tag
+// missing or drifted in at least one destination. Destinations failing to
+// list tags are skipped. Repositories which did not change since they were
+// completely synced are skipped without listing destinations.
+func (r *runner) processGetTagsJob(ctx context.Context, job getTagsJob) ([]retagJob, error) {
+	allSrcTags, err := job.Src.ListTags(ctx, job.Repo)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	tagsTotal.WithLabelValues(job.Src.Name(), job.Repo).Set(float64(len(allSrcTags)))
+
+	srcTags := r.flag.config.Repositories.TagFilter(job.Repo).Filter(allSrcTags)
+	if filtered := len(allSrcTags) - len(srcTags); filtered > 0 {
+		r.logger.LogCtx(ctx, "level", "debug", "message", "filtered out tags", "registry", job.Src.Name(), "repository", job.Repo, "filtered", filtered, "tags", len(allSrcTags))
+	}
+	filteredTags.WithLabelValues(job.Src.Name(), job.Repo).Set(float64(len(allSrcTags) - len(srcTags)))
+
+	srcDigests := newDigestCache(job.Src, job.Repo)
+
+	var fp string
+	if r.plan == nil && r.verification == nil {
+		fp, err = fingerprint(ctx, job, allSrcTags, srcTags, srcDigests)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		if !job.Full && r.unchanged(ctx, job, fp) {
+			return nil, nil
+		}
+	}
+
+	targets := make([]map[string]target, len(job.Dsts))
+	errs := make([]error, len(job.Dsts))
+	incomplete := make([]bool, len(job.Dsts))
+	wg := sync.WaitGroup{}
+	for i, dst := range job.Dsts {
+		wg.Add(1)
+		go func(i int, dst destination) {
+			defer wg.Done()
+
+			dstRepo := dst.Mapping.Repository(job.Repo)
+
+			dstTags, err := dst.Registry.ListTags(ctx, dstRepo)
+			if err != nil {
+				r.logger.LogCtx(ctx, "level", "error", "message", "failed to list tags", "registry", dst.Registry.Name(), "repository", dstRepo, "sourceRepository", job.Repo, "operation", registry.OperationListTags, "error", err)
+				errorsTotal.WithLabelValues(dst.Registry.Name()).Inc()
+				errs[i] = err
+				return
+			}
+			tagsTotal.WithLabelValues(dst.Registry.Name(), dstRepo).Set(float64(len(dstTags)))
+
+			ts, err := r.tagsToSync(ctx, job, srcTags, srcDigests, dst, dstTags)
+			if err != nil {
+				r.logger.LogCtx(ctx, "level", "error", "message", "failed to compare tags", "registry", dst.Registry.Name(), "repository", dstRepo, "sourceRepository", job.Repo, "error", err)
+				errorsTotal.WithLabelValues(dst.Registry.Name()).Inc()
+				errs[i] = err
+				return
+			}
+
+			targets[i] = ts
+
+			if dst.Mirror.Enabled && r.verification == nil {
+				incomplete[i] = !r.deleteTags(ctx, job, dst, dstTags, tagsToDelete(allSrcTags, dstTags, dst))
+			}
+		}(i, dst)
+	}
+	wg.Wait()
+
+	if r.verification != nil {
+		r.verifyTags(ctx, job, srcTags, srcDigests, targets, errs)
+		return nil, nil
+	}
+
+	repo := &repositorySync{
+		repository: state.Repository{
+			Registry:    job.Src.Name(),
+			Repository:  job.Repo,
+			Fingerprint: fp,
+		},
+	}
+	for i := range job.Dsts {
+		if errs[i] != nil && repo.err == nil {
+			repo.err = errs[i]
+		}
+		if incomplete[i] {
+			repo.incomplete = true
+		}
+	}
+
+	// Group destinations by tag so every tag is fetched from the source
+	// registry only once.
+	var jobs []retagJob
+	for _, t := range srcTags {
+		var dsts []target
+		for i := range job.Dsts {
+			if dst, ok := targets[i][t]; ok {
+				dsts = append(dsts, dst)
+			}
+		}
+		if len(dsts) == 0 {
+			continue
+		}
+
+		// The digest is already known for tags compared by digest. It is
+		// only fetched here for tags missing in all destinations.
+		d, err := srcDigests.Get(ctx, t)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		j := retagJob{
+			Src:  job.Src,
+			Dsts: dsts,
+
+			Repo:       job.Repo,
+			Tag:        t,
+			Digest:     d,
+			Repository: repo,
+		}
+		jobs = append(jobs, j)
+	}
+
+	repo.remaining = len(jobs)
+	if len(jobs) == 0 && r.plan == nil {
+		r.recordRepository(ctx, repo)
+	}
+
+	return jobs, nil
+}
+
+// tagsToSync returns targets of source tags missing in dst or pointing to
+// a different digest there keyed by the source tag. Repository and tag names
+// are mapped with the destination mapping. When multiple source tags are
+// mapped to the same destination tag only the first one is synced and the
+// collision is reported.
+func (r *runner) tagsToSync(ctx context.Context, job getTagsJob, srcTags []string, srcDigests *digestCache, dst destination, dstTags []string) (map[string]target, error) {
+	dstRepo := dst.Mapping.Repository(job.Repo)
+
+	existing := map[string]bool{}
+	for _, t := range dstTags {
+		existing[t] = true
+	}
+
+	mapped := map[string]string{}
+	missing := map[string]target{}
+	present := map[string]target{}
+	for _, t := range srcTags {
+		dstTag := dst.Mapping.Tag(t)
+		if other, ok := mapped[dstTag]; ok {
+			r.logger.LogCtx(ctx, "level", "error", "message", "tags are mapped to the same destination tag, skipping", "registry", dst.Registry.Name(), "repository", dstRepo, "tag", dstTag, "sourceRepository", job.Repo, "sourceTag", t, "otherSourceTag", other)
+			errorsTotal.WithLabelValues(dst.Registry.Name()).Inc()
+			continue
+		}
+		mapped[dstTag] = t
+
+		tt := target{
+			Registry: dst.Registry,
+			Repo:     dstRepo,
+			Tag:      dstTag,
+		}
+		if existing[dstTag] {
+			present[t] = tt
+		} else {
+			missing[t] = tt
+		}
+	}
+
+	// Tags existing in both registries are compared by digest to find the
+	// ones re-pushed with different content in the source registry.
+	drifted, err := r.driftedTags(ctx, job, present, srcDigests)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if len(drifted) > 0 {
+		r.logger.LogCtx(ctx, "level", "info", "message", "found tags with different digests", "registry", dst.Registry.Name(), "repository", dstRepo, "sourceRepository", job.Repo, "tags", len(drifted))
+		driftedTagsTotal.WithLabelValues(dst.Registry.Name(), dstRepo).Add(float64(len(drifted)))
+	}
+
+	for t, d := range drifted {
+		tt := present[t]
+		tt.Drifted = true
+		tt.Digest = d
+		missing[t] = tt
+	}
+
+	return missing, nil
+}
+
+// driftedTags returns source tags which digests differ from their targets
+// together with the digests found in the destination registry. Digests
+// recorded in the state are used instead of asking the destination registry
+// for tags which were synced without errors. Tags found in sync are recorded
+// in the state at once.
+func (r *runner) driftedTags(ctx context.Context, job getTagsJob, targets map[string]target, srcDigests *digestCache) (map[string]digest.Digest, error) {
+	drifted := map[string]digest.Digest{}
+	var inSync []state.Tag
+
+	for t, tt := range targets {
+		var srcDigest, dstDigest digest.Digest
+
+		s, ok, err := r.state.GetTag(ctx, tt.Registry.Name(), tt.Repo, tt.Tag)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		if !job.Full && ok && s.LastError == "" && s.Digest != "" {
+			srcDigest, err = srcDigests.Get(ctx, t)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+
+			if srcDigest != s.Digest {
+				drifted[t] = s.Digest
+			}
+			continue
+		}
+
+		eg := new(errgroup.Group)
+		eg.Go(func() error {
+			var err error
+			srcDigest, err = srcDigests.Get(ctx, t)
+			return microerror.Mask(err)
+		})
+		eg.Go(func() error {
+			var err error
+			dstDigest, err = tt.Registry.Digest(ctx, tt.Repo, tt.Tag)
+			return microerror.Mask(err)
+		})
+		err = eg.Wait()
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		if srcDigest != dstDigest {
+			drifted[t] = dstDigest
+			continue
+		}
+
+		inSync = append(inSync, state.Tag{
+			Registry:         tt.Registry.Name(),
+			Repository:       tt.Repo,
+			Tag:              tt.Tag,
+			SourceRepository: job.Repo,
+			SourceTag:        t,
+			Digest:           dstDigest,
+			SyncedAt:         time.Now(),
+		})
+	}
+
+	r.putTags(ctx, inSync)
+
+	return drifted, nil
+}
+
+// processRetagJob copies the tag to all destinations of the job. The
+// returned slice holds the error for the destination with the same index or
+// nil when the copy succeeded. Copies failing with retryable errors are
+// retried with pull attempts of the source registry when reading the source
+// failed and with push attempts of the destination registry otherwise.
+func (r *runner) processRetagJob(ctx context.Context, job retagJob) []error {
+	errs := make([]error, len(job.Dsts))
+
+	all := make([]int, len(job.Dsts))
+	for i := range all {
+		all[i] = i
+	}
+
+	srcFailed, dstFailed := r.copyTargets(ctx, job, all, errs)
+
+	// The source is read once for all the destinations so they are retried
+	// together.
+	if len(srcFailed) > 0 {
+		_ = r.retries[job.Src].Retry(ctx, registry.OperationPull, errs[srcFailed[0]], func() error {
+			var failed []int
+			srcFailed, failed = r.copyTargets(ctx, job, srcFailed, errs)
+			dstFailed = append(dstFailed, failed...)
+			if len(srcFailed) == 0 {
+				return nil
+			}
+			return errs[srcFailed[0]]
+		})
+	}
+
+	for _, i := range dstFailed {
+		_ = r.retries[job.Dsts[i].Registry].Retry(ctx, registry.OperationPush, errs[i], func() error {
+			srcFailed, dstFailed := r.copyTargets(ctx, job, []int{i}, errs)
+			if len(srcFailed) == 0 && len(dstFailed) == 0 {
+				return nil
+			}
+			return errs[i]
+		})
+	}
+
+	return errs
+}
+
+// copyTargets copies the tag to the destinations of the job with the given
+// indexes and records their errors in errs. It returns indexes of the ones
+// which failed while copying because of the source and because of the
+// destination. Failures of opening images are not returned because they are
+// retried by the registries.
+func (r *runner) copyTargets(ctx context.Context, job retagJob, indexes []int, errs []error) ([]int, []int) {
+	for _, i := range indexes {
+		errs[i] = nil
+	}
+
+	src, err := job.Src.ImageSource(ctx, job.Repo, job.Tag)
+	if err != nil {
+		for _, i := range indexes {
+			errs[i] = microerror.Mask(err)
+		}
+		return nil, nil
+	}
+	defer src.Close()
+
+	var dsts []types.ImageDestination
+	var dstIndexes []int
+	for _, i := range indexes {
+		d := job.Dsts[i]
+
+		dst, err := d.Registry.ImageDestination(ctx, d.Repo, d.Tag)
+		if err != nil {
+			errs[i] = microerror.Mask(err)
+			continue
+		}
+		defer dst.Close()
+
+		dsts = append(dsts, dst)
+		dstIndexes = append(dstIndexes, i)
+	}
+	if len(dsts) == 0 {
+		return nil, nil
+	}
+
+	start := time.Now()
+	stats, copyErrs := r.copier.Copy(ctx, src, dsts)
+
+	transferredBytesTotal.WithLabelValues(job.Src.Name(), directionPull).Add(float64(stats.PulledBytes))
+	for i, n := range stats.PushedBytes {
+		name := job.Dsts[dstIndexes[i]].Registry.Name()
+		transferredBytesTotal.WithLabelValues(name, directionPush).Add(float64(n))
+		operationDuration.WithLabelValues(name, operationCopy).Observe(time.Since(start).Seconds())
+	}
+
+	var srcFailed, dstFailed []int
+	for i, err := range copyErrs {
+		if err == nil {
+			continue
+		}
+
+		errs[dstIndexes[i]] = microerror.Mask(err)
+
+		// Source errors are reported for every destination but throttle
+		// the source once.
+		if copier.IsSourceFailed(err) {
+			if len(srcFailed) == 0 {
+				r.throttled(job.Src, err)
+			}
+			srcFailed = append(srcFailed, dstIndexes[i])
+		} else {
+			r.throttled(job.Dsts[dstIndexes[i]].Registry, err)
+			dstFailed = append(dstFailed, dstIndexes[i])
+		}
+	}
+
+	return srcFailed, dstFailed
+}
+
+// planRetagJob records the copy of the tag to all destinations of the job in
+// the plan. The size of the copy is computed from the source manifests.
+func (r *runner) planRetagJob(ctx context.Context, job retagJob) {
+	var size int64
+	{
+		src, err := job.Src.ImageSource(ctx, job.Repo, job.Tag)
+		if err == nil {
+			size, err = r.copier.Size(ctx, src)
+			src.Close()
+		}
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "error", "message", "failed to compute size", "registry", job.Src.Name(), "repository", job.Repo, "tag", job.Tag, "error", err)
+			errorsTotal.WithLabelValues(job.Src.Name()).Inc()
+		}
+	}
+
+	for _, dst := range job.Dsts {
+		action := planActionCopy
+		if dst.Drifted {
+			action = planActionResync
+		}
+
+		r.plan.Add(planEntry{
+			Registry:         dst.Registry.Name(),
+			Repository:       dst.Repo,
+			Tag:              dst.Tag,
+			SourceRepository: job.Repo,
+			SourceTag:        job.Tag,
+			Action:           action,
+			Bytes:            size,
+		})
+	}
+}
+
+// verifyTags records the result of comparing source tags with all
+// destinations of the job. Tags missing or drifted in a destination are
+// inconsistent. With --verify-blobs blobs of the other tags are checked in
+// the destination too.
+func (r *runner) verifyTags(ctx context.Context, job getTagsJob, srcTags []string, srcDigests *digestCache, targets []map[string]target, errs []error) {
+	r.verification.AddRepository()
+
+	for i, dst := range job.Dsts {
+		name := dst.Registry.Name()
+		dstRepo := dst.Mapping.Repository(job.Repo)
+
+		if errs[i] != nil {
+			r.verification.Add(verifyEntry{
+				Registry:         name,
+				Repository:       dstRepo,
+				SourceRepository: job.Repo,
+				Status:           verifyStatusError,
+				Error:            errs[i].Error(),
+			})
+			continue
+		}
+
+		for _, t := range srcTags {
+			e := verifyEntry{
+				Registry:         name,
+				Repository:       dstRepo,
+				Tag:              dst.Mapping.Tag(t),
+				SourceRepository: job.Repo,
+				SourceTag:        t,
+			}
+
+			if tt, ok := targets[i][t]; ok {
+				if tt.Drifted {
+					e.Status = verifyStatusMismatch
+					e.SourceDigest, _ = srcDigests.Get(ctx, t)
+					e.Digest = tt.Digest
+				} else {
+					e.Status = verifyStatusMissing
+				}
+				r.verification.Add(e)
+				continue
+			}
+
+			if r.flag.VerifyBlobs {
+				missing, err := r.missingBlobs(ctx, job.Src, job.Repo, t, dst.Registry, e.Repository, e.Tag)
+				if err != nil {
+					r.logger.LogCtx(ctx, "level", "error", "message", "failed to verify blobs", "registry", name, "repository", e.Repository, "tag", e.Tag, "sourceRepository", job.Repo, "sourceTag", t, "error", err)
+					errorsTotal.WithLabelValues(name).Inc()
+					e.Status = verifyStatusError
+					e.Error = err.Error()
+					r.verification.Add(e)
+					continue
+				}
+				if len(missing) > 0 {
+					e.Status = verifyStatusMissingBlobs
+					e.MissingBlobs = missing
+					r.verification.Add(e)
+					continue
+				}
+			}
+
+			r.verification.AddOK(1)
+		}
+	}
+}
+
+func (r *runner) missingBlobs(ctx context.Context, src registry.Interface, srcRepo, srcTag string, dst registry.Interface, dstRepo, dstTag string) ([]digest.Digest, error) {
+	s, err := src.ImageSource(ctx, srcRepo, srcTag)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	defer s.Close()
+
+	d, err := dst.ImageDestination(ctx, dstRepo, dstTag)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	defer d.Close()
+
+	missing, err := r.copier.MissingBlobs(ctx, s, d)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return missing, nil
+}
+
+// tagsToDelete returns destination tags not existing in the source
+// repository after mapping source tag names. Protected tags are never
+// returned.
+func tagsToDelete(srcTags, dstTags []string, dst destination) []string {
+	mapped := map[string]bool{}
+	for _, t := range srcTags {
+		mapped[dst.Mapping.Tag(t)] = true
+	}
+
+	var tags []string
+	for _, t := range dstTags {
+		if mapped[t] || dst.Mirror.Protects(t) {
+			continue
+		}
+		tags = append(tags, t)
+	}
+
+	return tags
+}
+
+// deleteTags deletes the tags from the destination repository applying the
+// mirror mode safeguards of the destination. Since when the tags are absent
+// in the source registry is recorded in the state. It returns false when
+// some of the tags were not deleted in this iteration.
+func (r *runner) deleteTags(ctx context.Context, job getTagsJob, dst destination, dstTags, tags []string) bool {
+	name := dst.Registry.Name()
+	dstRepo := dst.Mapping.Repository(job.Repo)
+
+	absentSince, err := r.updateAbsentTags(ctx, name, dstRepo, tags, time.Now())
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to record tags absent in source registry", "registry", name, "repository", dstRepo, "sourceRepository", job.Repo, "error", err)
+		errorsTotal.WithLabelValues(name).Inc()
+		return false
+	}
+	if len(tags) == 0 {
+		return true
+	}
+
+	// Deleting a big part of the repository is more likely caused by
+	// a broken source listing than by real deletions. The repository is
+	// not recorded as completely synced so deleting is attempted again
+	// once the listing recovers or the limit is raised.
+	if dst.Mirror.ExceedsMaxDeletionsPercent(len(tags), len(dstTags)) {
+		r.logger.LogCtx(ctx, "level", "warning", "message", "skipping deleting tags exceeding the limit of deletions percent", "registry", name, "repository", dstRepo, "sourceRepository", job.Repo, "tags", len(tags), "existing", len(dstTags), "maxDeletionsPercent", *dst.Mirror.MaxDeletionsPercent)
+		return false
+	}
+
+	// keptDigests maps digests of manifests referenced by tags which are
+	// kept to one of those tags. Registries deleting by manifest digest
+	// remove all the tags referencing the manifest so tags sharing it with
+	// a kept tag are not deleted. It is resolved with the first tag
+	// deleted.
+	var keptDigests map[digest.Digest]string
+
+	complete := true
+	for _, t := range tags {
+		if absent := time.Since(absentSince[t]); absent < dst.Mirror.GracePeriod {
+			r.logger.LogCtx(ctx, "level", "debug", "message", "tag absent in source registry is deleted after grace period", "registry", name, "repository", dstRepo, "tag", t, "sourceRepository", job.Repo, "absent", absent.Round(time.Second), "gracePeriod", dst.Mirror.GracePeriod)
+			complete = false
+			continue
+		}
+
+		if keptDigests == nil {
+			keptDigests, err = keptTagDigests(ctx, dst.Registry, dstRepo, dstTags, tags)
+			if err != nil {
+				r.logger.LogCtx(ctx, "level", "error", "message", "failed to get digests of kept tags, skipping deleting tags", "registry", name, "repository", dstRepo, "sourceRepository", job.Repo, "operation", registry.OperationDigest, "error", err)
+				errorsTotal.WithLabelValues(name).Inc()
+				return false
+			}
+		}
+
+		dgst, err := dst.Registry.Digest(ctx, dstRepo, t)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "error", "message", "failed to get digest of tag to delete", "registry", name, "repository", dstRepo, "tag", t, "sourceRepository", job.Repo, "operation", registry.OperationDigest, "error", err)
+			errorsTotal.WithLabelValues(name).Inc()
+			complete = false
+			continue
+		}
+		if kept, ok := keptDigests[dgst]; ok {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "skipping deleting tag referencing the same manifest as a kept tag", "registry", name, "repository", dstRepo, "tag", t, "keptTag", kept, "digest", dgst.String(), "sourceRepository", job.Repo)
+			continue
+		}
+
+		if atomic.AddInt64(r.deletionsLeft[dst.Registry], -1) < 0 {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "skipping deleting tag, the limit of deletions per sync is reached", "registry", name, "repository", dstRepo, "tag", t, "sourceRepository", job.Repo, "maxDeletions", dst.Mirror.MaxDeletions)
+			complete = false
+			continue
+		}
+
+		if r.plan != nil {
+			r.plan.Add(planEntry{
+				Registry:         name,
+				Repository:       dstRepo,
+				Tag:              t,
+				SourceRepository: job.Repo,
+				Action:           planActionDelete,
+			})
+			_ = atomic.AddInt64(r.deletionsDone[dst.Registry], 1)
+			continue
+		}
+
+		if dst.Mirror.DryRun {
+			r.logger.LogCtx(ctx, "level", "info", "message", "would delete tag (dry run)", "registry", name, "repository", dstRepo, "tag", t, "sourceRepository", job.Repo)
+			_ = atomic.AddInt64(r.deletionsDone[dst.Registry], 1)
+			continue
+		}
+
+		r.logger.LogCtx(ctx, "level", "info", "message", "deleting tag", "registry", name, "repository", dstRepo, "tag", t, "sourceRepository", job.Repo)
+
+		err = dst.Registry.DeleteTag(ctx, dstRepo, t)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "error", "message", "failed to delete tag", "registry", name, "repository", dstRepo, "tag", t, "sourceRepository", job.Repo, "operation", registry.OperationDelete, "error", err)
+			errorsTotal.WithLabelValues(name).Inc()
+			complete = false
+			continue
+		}
+
+		err = r.state.DeleteTag(ctx, name, dstRepo, t)
+		if err != nil {
+			r.logger.LogCtx(ctx, "level", "error", "message", "failed to delete state of tag", "registry", name, "repository", dstRepo, "tag", t, "error", err)
+			errorsTotal.WithLabelValues(name).Inc()
+		}
+
+		deletedTagsTotal.WithLabelValues(name, dstRepo).Inc()
+		_ = atomic.AddInt64(r.deletionsDone[dst.Registry], 1)
+	}
+
+	return complete
+}
+
+// keptTagDigests returns digests of manifests referenced by the destination
+// tags which are not deleted mapped to one of those tags.
+func keptTagDigests(ctx context.Context, reg registry.Interface, repo string, dstTags, deleted []string) (map[digest.Digest]string, error) {
+	deleting := map[string]bool{}
+	for _, t := range deleted {
+		deleting[t] = true
+	}
+
+	digests := map[digest.Digest]string{}
+	for _, t := range dstTags {
+		if deleting[t] {
+			continue
+		}
+
+		dgst, err := reg.Digest(ctx, repo, t)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		digests[dgst] = t
+	}
+
+	return digests, nil
+}
+
+// updateAbsentTags records tags of the destination repository currently
+// absent in the source registry and returns since when each of them is
+// absent. Tags which are not absent anymore are forgotten.
+func (r *runner) updateAbsentTags(ctx context.Context, registryName, repo string, tags []string, now time.Time) (map[string]time.Time, error) {
+	records, err := r.state.Tags(ctx, registryName, repo)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	absent := map[string]bool{}
+	for _, t := range tags {
+		absent[t] = true
+	}
+
+	var changed []state.Tag
+	existing := map[string]state.Tag{}
+	for _, s := range records {
+		existing[s.Tag] = s
+
+		if !absent[s.Tag] && !s.AbsentSince.IsZero() {
+			s.AbsentSince = time.Time{}
+			changed = append(changed, s)
+		}
+	}
+
+	since := map[string]time.Time{}
+	for _, t := range tags {
+		s, ok := existing[t]
+		if ok && !s.AbsentSince.IsZero() {
+			since[t] = s.AbsentSince
+			continue
+		}
+		if !ok {
+			s = state.Tag{
+				Registry:   registryName,
+				Repository: repo,
+				Tag:        t,
+			}
+		}
+
+		s.AbsentSince = now
+		changed = append(changed, s)
+
+		since[t] = now
+	}
+
+	err = r.state.PutTags(ctx, changed)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return since, nil
+}
+
+func (r *runner) logLimits(ctx context.Context, reg config.Registry) {
+	concurrency := "unlimited"
+	if reg.Limits.Concurrency > 0 {
+		concurrency = fmt.Sprint(reg.Limits.Concurrency)
+	}
+
+	l := reg.Limits
+	r.logger.LogCtx(ctx,
+		"level", "info",
+		"message", "limits",
+		"registry", reg.Name,
+		"concurrency", concurrency,
+		registry.OperationListRepositories, l.ListRepositories,
+		registry.OperationListTags, l.ListTags,
+		registry.OperationDigest, l.Digest,
+		registry.OperationPull, l.Pull,
+		registry.OperationPush, l.Push,
+		registry.OperationDelete, l.Delete,
+	)
+
+	a := reg.Retry.Attempts
+	r.logger.LogCtx(ctx,
+		"level", "info",
+		"message", "retries",
+		"registry", reg.Name,
+		registry.OperationListRepositories, a.ListRepositories,
+		registry.OperationListTags, a.ListTags,
+		registry.OperationDigest, a.Digest,
+		registry.OperationPull, a.Pull,
+		registry.OperationPush, a.Push,
+		registry.OperationDelete, a.Delete,
+		"backoff", reg.Retry.Backoff,
+		"maxBackoff", reg.Retry.MaxBackoff,
+	)
+}
+
+// throttled slows down operations of the registry when the error shows it
+// throttles requests. Copying images is not rate limited by the decorated
+// registry so throttling is detected from its errors.
+func (r *runner) throttled(reg registry.Interface, err error) {
+	t, ok := r.throttles[reg]
+	if ok && registry.IsTooManyRequests(err) {
+		t.Throttled(0)
+	}
+}
+
+// newThrottle returns the throttle of the registry reporting its state in
+// metrics. It logs every time the registry makes syncing slow down.
+func (r *runner) newThrottle(name string) (*registry.Throttle, error) {
+	factor := 1.0
+	var blockedUntil time.Time
+
+	c := registry.ThrottleConfig{
+		OnChange: func(s registry.ThrottleState) {
+			if s.Factor < factor {
+				r.logger.Log("level", "warning", "message", "registry throttles requests, slowing down", "registry", name, "factor", s.Factor)
+			}
+			if now := time.Now(); s.BlockedUntil.After(now) && !blockedUntil.After(now) {
+				r.logger.Log("level", "warning", "message", "registry asked to pause requests", "registry", name, "until", s.BlockedUntil.Format(time.RFC3339))
+			}
+			factor = s.Factor
+			blockedUntil = s.BlockedUntil
+
+			for operation, limit := range s.Rates {
+				rateLimit.WithLabelValues(name, operation).Set(limit)
+			}
+			if s.Remaining >= 0 {
+				rateLimitRemaining.WithLabelValues(name).Set(float64(s.Remaining))
+			}
+		},
+	}
+
+	t, err := registry.NewThrottle(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return t, nil
+}
+
+// newTransport returns the transport of registry clients. Insecure registries
+// are talked to without TLS verification, like containers/image does.
+func newTransport(insecure bool) http.RoundTripper {
+	if !insecure {
+		return http.DefaultTransport
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true, // nolint
+	}
+
+	return t
+}
+
+// newQuotaProbe returns the probe observing the pull quota of the source
+// registry with the given HTTP client. Only Docker Hub reports a quota which
+// is not counted down by the probe itself so other registries are not probed.
+func newQuotaProbe(name string, httpClient *http.Client) (registry.QuotaProbe, error) {
+	if name != dockerHubRegistryName {
+		return nil, nil
+	}
+
+	c := distribution.Config{
+		RegistryName: dockerHubRegistryHost,
+		HTTPClient:   httpClient,
+	}
+
+	probe, err := distribution.New(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return probe, nil
+}
+
+// newRetryPolicy returns the retry policy of the registry which logs and
+// counts retries.
+func (r *runner) newRetryPolicy(name string, retry config.Retry) registry.RetryPolicy {
+	a := retry.Attempts
+
+	return registry.RetryPolicy{
+		Attempts: map[string]int{
+			registry.OperationListRepositories: a.ListRepositories,
+			registry.OperationListTags:         a.ListTags,
+			registry.OperationDigest:           a.Digest,
+			registry.OperationPull:             a.Pull,
+			registry.OperationPush:             a.Push,
+			registry.OperationDelete:           a.Delete,
+		},
+		Backoff:    retry.Backoff,
+		MaxBackoff: retry.MaxBackoff,
+		OnRetry: func(ctx context.Context, operation string, attempt int, err error) {
+			r.logger.LogCtx(ctx, "level", "warning", "message", "retrying operation", "registry", name, "operation", operation, "attempt", attempt, "error", err)
+			retriesTotal.WithLabelValues(name, operation).Inc()
+		},
+	}
+}
+
+func newRetryingRegistry(reg registry.Interface, retry registry.RetryPolicy) (*registry.RetryingRegistry, error) {
+	c := registry.RetryingRegistryConfig{
+		Policy:     retry,
+		Underlying: reg,
+	}
+
+	r, err := registry.NewRetryingRegistry(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return r, nil
+}
+
+func (r *runner) newDecoratedRegistry(reg registry.Interface, limits config.Limits, throttle *registry.Throttle) (*registry.DecoratedRegistry, error) {
+	// Zero interval makes an unlimited limiter.
+	newLimiter := func(r config.RateLimit) *rate.Limiter {
+		return rate.NewLimiter(rate.Every(*r.Interval), r.Burst)
+	}
+
+	c := registry.DecoratedRegistryConfig{
+		Concurrency: limits.Concurrency,
+		RateLimiter: registry.DecoratedRegistryConfigRateLimiter{
+			ListRepositories: newLimiter(limits.ListRepositories),
+			ListTags:         newLimiter(limits.ListTags),
+			Digest:           newLimiter(limits.Digest),
+			Pull:             newLimiter(limits.Pull),
+			Push:             newLimiter(limits.Push),
+			Delete:           newLimiter(limits.Delete),
+		},
+		Throttle: throttle,
+		OnDone: func(operation string, duration time.Duration, err error) {
+			operationDuration.WithLabelValues(reg.Name(), operation).Observe(duration.Seconds())
+		},
+		TracerProvider: r.tracerProvider,
+		Underlying:     reg,
+	}
+
+	d, err := registry.NewDecoratedRegistry(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,270 @@
+package sync
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/crsync/pkg/config"
+)
+
+const (
+	scheduleFull        = "full"
+	scheduleIncremental = "incremental"
+)
+
+// scheduler decides when iterations start when running in a loop and which
+// of them are full syncs. Iterations never run in parallel. A run becoming
+// due while an iteration of its own schedule is running is skipped. A run of
+// the other schedule becoming due in the meantime starts right after it and
+// further runs of that schedule due until then are skipped. Incremental runs
+// due when a full sync finishes are covered by it.
+type scheduler struct {
+	config config.Schedule
+	// fullEvery makes every fullEvery-th incremental iteration a full sync.
+	fullEvery int
+	// destinations are names of all destination registries.
+	destinations []string
+	// onSkip is called with the number of runs of the schedule skipped
+	// because an iteration was running.
+	onSkip func(key string, n int)
+
+	mutex sync.Mutex
+	// next is the time the next run of every configured schedule is due.
+	next            map[string]time.Time
+	incrementalRuns int
+	// leftOut are destinations left out of iterations because of quiet
+	// windows since the last full sync.
+	leftOut map[string]bool
+	skipped map[string]int
+}
+
+// scheduleStatus is the state of the scheduler served at /status.
+type scheduleStatus struct {
+	NextIncremental time.Time  `json:"nextIncremental"`
+	NextFull        *time.Time `json:"nextFull,omitempty"`
+	// QuietDestinations are destinations nothing is pushed to because they
+	// are in a quiet window.
+	QuietDestinations []string `json:"quietDestinations"`
+	// SkippedRuns counts runs of every schedule skipped because an
+	// iteration was running.
+	SkippedRuns map[string]int `json:"skippedRuns"`
+}
+
+// newScheduler returns the scheduler starting the first incremental
+// iteration at now.
+func newScheduler(c config.Schedule, fullEvery int, destinations []string, onSkip func(key string, n int), now time.Time) *scheduler {
+	s := &scheduler{
+		config:       c,
+		fullEvery:    fullEvery,
+		destinations: destinations,
+		onSkip:       onSkip,
+
+		next: map[string]time.Time{
+			scheduleIncremental: now,
+		},
+		leftOut: map[string]bool{},
+		skipped: map[string]int{
+			scheduleIncremental: 0,
+		},
+	}
+
+	if c.Full.Enabled() {
+		s.next[scheduleFull] = c.Full.Next(now)
+		s.skipped[scheduleFull] = 0
+	}
+
+	return s
+}
+
+// Next returns the schedule of the next iteration and the time it is due.
+// Full syncs take precedence over incremental iterations due at the same
+// time.
+func (s *scheduler) Next() (string, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, at := scheduleIncremental, s.next[scheduleIncremental]
+	if full, ok := s.next[scheduleFull]; ok && !full.After(at) {
+		key, at = scheduleFull, full
+	}
+
+	return key, at
+}
+
+// Start records the start of the iteration of the schedule and returns its
+// trigger. Every fullSync.every-th incremental iteration is a full sync. So
+// is the first incremental iteration after a quiet window of a destination
+// left out of iterations ended, because repositories modified during the
+// window may no longer be listed as modified.
+func (s *scheduler) Start(key string, now time.Time) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Runs due while webhooks were being synced are skipped.
+	s.skipOverdue(key, now)
+
+	trigger := triggerFull
+	if key == scheduleIncremental {
+		trigger = triggerIncremental
+
+		s.incrementalRuns++
+		if s.fullEvery > 0 && s.incrementalRuns%s.fullEvery == 0 {
+			trigger = triggerFull
+		}
+		for d := range s.leftOut {
+			if !s.config.Quiet(d, now) {
+				trigger = triggerFull
+			}
+		}
+	}
+
+	if trigger == triggerFull {
+		s.leftOut = map[string]bool{}
+	}
+
+	return trigger
+}
+
+// Finish schedules the next runs after the iteration of the schedule
+// started with the trigger finished at now.
+func (s *scheduler) Finish(key, trigger string, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for k, due := range s.next {
+		if due.After(now) {
+			continue
+		}
+
+		s.skipOverdue(k, now)
+
+		if k == key || trigger == triggerFull {
+			s.next[k] = s.recurrence(k).Next(now)
+		}
+	}
+}
+
+// Quiet returns true when nothing must be pushed to the destination at t.
+func (s *scheduler) Quiet(destination string, t time.Time) bool {
+	return s.config.Quiet(destination, t)
+}
+
+// LeftOut records the destination was left out of an iteration because it
+// was in a quiet window.
+func (s *scheduler) LeftOut(destination string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.leftOut[destination] = true
+}
+
+// Status returns when the next runs are due, destinations in quiet windows
+// at now and the number of skipped runs.
+func (s *scheduler) Status(now time.Time) scheduleStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := scheduleStatus{
+		NextIncremental:   s.next[scheduleIncremental],
+		QuietDestinations: s.quietDestinations(now),
+		SkippedRuns:       map[string]int{},
+	}
+	if full, ok := s.next[scheduleFull]; ok {
+		res.NextFull = &full
+	}
+	for k, n := range s.skipped {
+		res.SkippedRuns[k] = n
+	}
+
+	return res
+}
+
+func (s *scheduler) quietDestinations(now time.Time) []string {
+	quiet := []string{}
+	for _, d := range s.destinations {
+		if s.config.Quiet(d, now) {
+			quiet = append(quiet, d)
+		}
+	}
+	sort.Strings(quiet)
+
+	return quiet
+}
+
+func (s *scheduler) recurrence(key string) config.Recurrence {
+	if key == scheduleFull {
+		return s.config.Full
+	}
+
+	return s.config.Incremental
+}
+
+// skipOverdue skips runs of the schedule which became due after its pending
+// run until now and moves the pending run to the last of them. Only cron
+// schedules can become overdue because intervals are counted from the end of
+// iterations. It must be called with the mutex locked.
+func (s *scheduler) skipOverdue(key string, now time.Time) {
+	r := s.recurrence(key)
+	if r.Cron == "" {
+		return
+	}
+
+	last := s.next[key]
+	var n int
+	for {
+		t := r.Next(last)
+		if t.After(now) || !t.After(last) {
+			break
+		}
+
+		n++
+		last = t
+	}
+
+	if n == 0 {
+		return
+	}
+
+	s.next[key] = last
+	s.skipped[key] += n
+	if s.onSkip != nil {
+		s.onSkip(key, n)
+	}
+}
+
+// leaveOutQuiet returns destinations which are not in quiet windows and
+// records the other ones as left out. It is called when an iteration starts
+// and again before every repository is scheduled so a window opening while
+// the iteration runs stops pushing to its destinations. Repositories already
+// scheduled finish. Plans and verifications never push so they keep all the
+// destinations.
+func (r *runner) leaveOutQuiet(ctx context.Context, dsts []destination, now time.Time) []destination {
+	if r.plan != nil || r.verification != nil {
+		return dsts
+	}
+
+	var active []destination
+	for _, dst := range dsts {
+		name := dst.Registry.Name()
+		quiet := r.scheduler.Quiet(name, now)
+		r.status.Quiet(name, quiet)
+		if quiet {
+			r.quietMutex.Lock()
+			logged := r.quietLeftOut[name]
+			r.quietLeftOut[name] = true
+			r.quietMutex.Unlock()
+
+			if !logged {
+				r.logger.LogCtx(ctx, "level", "info", "message", "destination registry is in quiet window, leaving it out", "registry", name)
+			}
+			r.scheduler.LeftOut(name)
+			continue
+		}
+
+		active = append(active, dst)
+	}
+
+	return active
+}
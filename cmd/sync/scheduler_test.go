@@ -0,0 +1,247 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/crsync/pkg/config"
+	"github.com/giantswarm/crsync/pkg/logger"
+	"github.com/giantswarm/crsync/pkg/registry"
+)
+
+// namedRegistry is a registry only answering its name.
+type namedRegistry struct {
+	registry.Interface
+	name string
+}
+
+func (r namedRegistry) Name() string {
+	return r.name
+}
+
+// newTestSchedule returns the schedule validated together with a source and
+// destinations "docker.io" and "gsoci.azurecr.io".
+func newTestSchedule(t *testing.T, s config.Schedule) config.Schedule {
+	newRegistry := func(name string) config.Registry {
+		return config.Registry{
+			Name: name,
+			Credentials: config.Credentials{
+				User:     "user",
+				Password: config.Secret{Value: "password"},
+			},
+		}
+	}
+
+	c := &config.Config{
+		Source: config.Source{
+			Registry: newRegistry("quay.io"),
+		},
+		Destinations: []config.Destination{
+			{Registry: newRegistry("docker.io")},
+			{Registry: newRegistry("gsoci.azurecr.io")},
+		},
+		Schedule: s,
+	}
+	c.Default()
+
+	err := c.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.Schedule
+}
+
+func at(hour, minute, second int) time.Time {
+	return time.Date(2024, 7, 1, hour, minute, second, 0, time.UTC)
+}
+
+func Test_scheduler_FullEvery(t *testing.T) {
+	c := newTestSchedule(t, config.Schedule{
+		Incremental: config.Recurrence{Interval: 30 * time.Second},
+	})
+	s := newScheduler(c, 3, nil, nil, at(10, 0, 0))
+
+	var triggers []string
+	now := at(10, 0, 0)
+	for i := 0; i < 4; i++ {
+		key, due := s.Next()
+		if key != scheduleIncremental || !due.Equal(now) {
+			t.Fatalf("next run is %s at %s, want %s at %s", key, due, scheduleIncremental, now)
+		}
+
+		trigger := s.Start(key, now)
+		triggers = append(triggers, trigger)
+
+		// Intervals are counted from the end of iterations.
+		now = now.Add(10 * time.Second)
+		s.Finish(key, trigger, now)
+		now = now.Add(30 * time.Second)
+	}
+
+	expected := []string{triggerIncremental, triggerIncremental, triggerFull, triggerIncremental}
+	if !reflect.DeepEqual(triggers, expected) {
+		t.Fatalf("triggers == %v, want %v", triggers, expected)
+	}
+}
+
+func Test_scheduler_SkipOverdue(t *testing.T) {
+	c := newTestSchedule(t, config.Schedule{
+		Incremental: config.Recurrence{Cron: "*/5 * * * *"},
+	})
+
+	skipped := map[string]int{}
+	onSkip := func(key string, n int) {
+		skipped[key] += n
+	}
+	s := newScheduler(c, 0, nil, onSkip, at(10, 0, 0))
+
+	trigger := s.Start(scheduleIncremental, at(10, 0, 0))
+
+	// Runs at 10:05, 10:10 and 10:15 became due while the iteration was
+	// running.
+	s.Finish(scheduleIncremental, trigger, at(10, 17, 0))
+	if _, due := s.Next(); !due.Equal(at(10, 20, 0)) {
+		t.Fatalf("next run at %s, want %s", due, at(10, 20, 0))
+	}
+	if skipped[scheduleIncremental] != 3 {
+		t.Fatalf("%d runs skipped, want 3", skipped[scheduleIncremental])
+	}
+
+	// Runs at 10:20, 10:25 and 10:30 became due while webhooks were synced.
+	// Only the last one is started.
+	s.Start(scheduleIncremental, at(10, 31, 0))
+	if skipped[scheduleIncremental] != 5 {
+		t.Fatalf("%d runs skipped, want 5", skipped[scheduleIncremental])
+	}
+
+	st := s.Status(at(10, 31, 0))
+	if st.SkippedRuns[scheduleIncremental] != 5 {
+		t.Fatalf("status reports %d skipped runs, want 5", st.SkippedRuns[scheduleIncremental])
+	}
+}
+
+func Test_scheduler_FullSchedule(t *testing.T) {
+	c := newTestSchedule(t, config.Schedule{
+		Incremental: config.Recurrence{Interval: 30 * time.Second},
+		Full:        config.Recurrence{Cron: "0 3 * * *"},
+	})
+	s := newScheduler(c, 0, nil, nil, at(2, 59, 30))
+
+	key, due := s.Next()
+	if key != scheduleIncremental || !due.Equal(at(2, 59, 30)) {
+		t.Fatalf("next run is %s at %s, want %s at %s", key, due, scheduleIncremental, at(2, 59, 30))
+	}
+	trigger := s.Start(key, due)
+	if trigger != triggerIncremental {
+		t.Fatalf("trigger == %s, want %s", trigger, triggerIncremental)
+	}
+
+	// The full sync became due while the incremental iteration was
+	// running so it starts right after it.
+	s.Finish(key, trigger, at(3, 0, 10))
+	key, due = s.Next()
+	if key != scheduleFull || !due.Equal(at(3, 0, 0)) {
+		t.Fatalf("next run is %s at %s, want %s at %s", key, due, scheduleFull, at(3, 0, 0))
+	}
+	trigger = s.Start(key, at(3, 0, 10))
+	if trigger != triggerFull {
+		t.Fatalf("trigger == %s, want %s", trigger, triggerFull)
+	}
+
+	// The full sync covers the incremental run due when it finishes.
+	s.Finish(key, trigger, at(3, 5, 0))
+	key, due = s.Next()
+	if key != scheduleIncremental || !due.Equal(at(3, 5, 30)) {
+		t.Fatalf("next run is %s at %s, want %s at %s", key, due, scheduleIncremental, at(3, 5, 30))
+	}
+
+	st := s.Status(at(3, 5, 0))
+	if st.NextFull == nil || !st.NextFull.Equal(at(3, 0, 0).AddDate(0, 0, 1)) {
+		t.Fatalf("next full sync at %v, want %s", st.NextFull, at(3, 0, 0).AddDate(0, 0, 1))
+	}
+}
+
+func Test_scheduler_QuietWindow(t *testing.T) {
+	ctx := context.Background()
+
+	c := newTestSchedule(t, config.Schedule{
+		Incremental: config.Recurrence{Interval: 30 * time.Second},
+		QuietWindows: []config.QuietWindow{
+			{
+				Destinations: []string{"docker.io"},
+				Start:        "10:00",
+				End:          "11:00",
+			},
+		},
+	})
+
+	l, err := logger.New(logger.Config{IOWriter: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"docker.io", "gsoci.azurecr.io"}
+	r := &runner{
+		logger:       l,
+		status:       newStatus(names),
+		scheduler:    newScheduler(c, 0, names, nil, at(9, 59, 0)),
+		quietLeftOut: map[string]bool{},
+	}
+	dsts := []destination{
+		{Registry: namedRegistry{name: "docker.io"}},
+		{Registry: namedRegistry{name: "gsoci.azurecr.io"}},
+	}
+	activeNames := func(active []destination) []string {
+		var names []string
+		for _, d := range active {
+			names = append(names, d.Registry.Name())
+		}
+		return names
+	}
+
+	// The iteration starts before the window.
+	trigger := r.scheduler.Start(scheduleIncremental, at(9, 59, 0))
+	if trigger != triggerIncremental {
+		t.Fatalf("trigger == %s, want %s", trigger, triggerIncremental)
+	}
+	active := r.leaveOutQuiet(ctx, dsts, at(9, 59, 0))
+	if got := activeNames(active); !reflect.DeepEqual(got, names) {
+		t.Fatalf("active destinations == %v, want %v", got, names)
+	}
+
+	// Repositories scheduled after the window opens are not pushed to the
+	// quiet destination.
+	active = r.leaveOutQuiet(ctx, dsts, at(10, 0, 0))
+	if got, want := activeNames(active), []string{"gsoci.azurecr.io"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("active destinations == %v, want %v", got, want)
+	}
+	if st := r.scheduler.Status(at(10, 0, 0)); !reflect.DeepEqual(st.QuietDestinations, []string{"docker.io"}) {
+		t.Fatalf("quiet destinations == %v, want %v", st.QuietDestinations, []string{"docker.io"})
+	}
+
+	// Iterations during the window stay incremental.
+	r.scheduler.Finish(scheduleIncremental, trigger, at(10, 1, 0))
+	trigger = r.scheduler.Start(scheduleIncremental, at(10, 30, 0))
+	if trigger != triggerIncremental {
+		t.Fatalf("trigger == %s, want %s", trigger, triggerIncremental)
+	}
+
+	// The first iteration after the window is a full sync because
+	// repositories modified during the window may not be listed as
+	// modified anymore.
+	r.scheduler.Finish(scheduleIncremental, trigger, at(10, 31, 0))
+	trigger = r.scheduler.Start(scheduleIncremental, at(11, 0, 0))
+	if trigger != triggerFull {
+		t.Fatalf("trigger == %s, want %s", trigger, triggerFull)
+	}
+
+	r.scheduler.Finish(scheduleIncremental, trigger, at(11, 10, 0))
+	trigger = r.scheduler.Start(scheduleIncremental, at(11, 10, 30))
+	if trigger != triggerIncremental {
+		t.Fatalf("trigger == %s, want %s", trigger, triggerIncremental)
+	}
+}
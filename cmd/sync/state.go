@@ -0,0 +1,293 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/giantswarm/crsync/pkg/state"
+)
+
+// newStateStore returns the store configured with --state-file or the
+// configuration file. Plan works on an in-memory copy of the state so it
+// sees when tags were first seen absent, like the next sync does, but never
+// changes the state of sync. Verification always starts with an empty
+// in-memory state. Neither of them skips repositories.
+func (r *runner) newStateStore() (state.Store, error) {
+	path := r.flag.config.State.Path
+	if path == "" || r.verification != nil {
+		return state.NewMemory(), nil
+	}
+
+	c := state.BoltConfig{
+		Path: path,
+	}
+
+	if r.plan != nil {
+		s, err := state.LoadBolt(c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		return s, nil
+	}
+
+	s, err := state.NewBolt(c)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return s, nil
+}
+
+// fingerprint identifies source tags of the repository together with
+// destinations they are synced to. When the fingerprint does not change
+// between syncs the repository does not need to be synced again. When the
+// source registry reported when the repository was last modified, the
+// fingerprint is built from the listings only so unchanged repositories are
+// skipped without asking the source registry for digests. Otherwise tags
+// moved to other manifests are only noticed by their digests.
+func fingerprint(ctx context.Context, job getTagsJob, allSrcTags, srcTags []string, srcDigests *digestCache) (string, error) {
+	var lines []string
+	for _, t := range allSrcTags {
+		lines = append(lines, fmt.Sprintf("source %s", t))
+	}
+	if !job.LastModified.IsZero() {
+		lines = append(lines, fmt.Sprintf("modified %d", job.LastModified.Unix()))
+	}
+	for _, t := range srcTags {
+		var d digest.Digest
+		if job.LastModified.IsZero() {
+			var err error
+			d, err = srcDigests.Get(ctx, t)
+			if err != nil {
+				return "", microerror.Mask(err)
+			}
+		}
+
+		for _, dst := range job.Dsts {
+			lines = append(lines, fmt.Sprintf("%s/%s:%s@%s", dst.Registry.Name(), dst.Mapping.Repository(job.Repo), dst.Mapping.Tag(t), d))
+		}
+	}
+	for _, dst := range job.Dsts {
+		lines = append(lines, fmt.Sprintf("%s mirror=%t", dst.Registry.Name(), dst.Mirror.Enabled))
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, l := range lines {
+		fmt.Fprintln(h, l)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// unchanged returns true when the repository was completely synced with the
+// same fingerprint before.
+func (r *runner) unchanged(ctx context.Context, job getTagsJob, fingerprint string) bool {
+	s, ok, err := r.state.GetRepository(ctx, job.Src.Name(), job.Repo)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to get repository state", "registry", job.Src.Name(), "repository", job.Repo, "error", err)
+		errorsTotal.WithLabelValues(job.Src.Name()).Inc()
+		return false
+	}
+	if !ok || s.LastError != "" || s.Fingerprint != fingerprint {
+		return false
+	}
+
+	r.logger.LogCtx(ctx, "level", "debug", "message", "unchanged since last sync, skipping", "registry", job.Src.Name(), "repository", job.Repo, "syncedAt", s.SyncedAt.Format(time.RFC3339))
+	replicationLag.Synced(job.Src.Name(), job.Repo, time.Now())
+
+	return true
+}
+
+// failedRepositories returns source repositories which last sync failed and
+// which are not listed in repos. They are retried in every iteration until
+// they are synced even when the source registry does not list them as
+// modified.
+func (r *runner) failedRepositories(ctx context.Context, registry string, repos []string) []string {
+	all, err := r.state.Repositories(ctx, registry)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to get state of repositories", "registry", registry, "error", err)
+		errorsTotal.WithLabelValues(registry).Inc()
+		return nil
+	}
+
+	listed := map[string]bool{}
+	for _, repo := range repos {
+		listed[repo] = true
+	}
+
+	var count int
+	var failed []string
+	for _, s := range all {
+		if s.LastError == "" {
+			continue
+		}
+
+		count++
+		if !listed[s.Repository] {
+			failed = append(failed, s.Repository)
+		}
+	}
+
+	failedRepositories.WithLabelValues(registry).Set(float64(count))
+
+	return failed
+}
+
+// recordRepository records the result of syncing the repository once all its
+// retag jobs are done.
+func (r *runner) recordRepository(ctx context.Context, s *repositorySync) {
+	repo := s.repository
+
+	now := time.Now()
+	if s.err != nil {
+		prev, _, _ := r.state.GetRepository(ctx, repo.Registry, repo.Repository)
+		repo.Fingerprint = ""
+		repo.SyncedAt = prev.SyncedAt
+		repo.LastError = s.err.Error()
+		repo.LastErrorAt = now
+		replicationLag.Failed(repo.Registry, repo.Repository, prev.SyncedAt)
+	} else {
+		if s.incomplete {
+			repo.Fingerprint = ""
+		}
+		repo.SyncedAt = now
+		replicationLag.Synced(repo.Registry, repo.Repository, now)
+	}
+
+	err := r.state.PutRepository(ctx, repo)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to record state of repository", "registry", repo.Registry, "repository", repo.Repository, "error", err)
+		errorsTotal.WithLabelValues(repo.Registry).Inc()
+	}
+}
+
+// recordRetagJob records the result of copying the tag to every destination
+// of the job. errs holds errors returned by processRetagJob.
+func (r *runner) recordRetagJob(ctx context.Context, job retagJob, errs []error) {
+	var firstErr error
+
+	now := time.Now()
+	for i, dst := range job.Dsts {
+		t := state.Tag{
+			Registry:         dst.Registry.Name(),
+			Repository:       dst.Repo,
+			Tag:              dst.Tag,
+			SourceRepository: job.Repo,
+			SourceTag:        job.Tag,
+			Digest:           job.Digest,
+			SyncedAt:         now,
+		}
+
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+
+			// Keep the digest and time of the last successful sync.
+			prev, ok, _ := r.state.GetTag(ctx, t.Registry, t.Repository, t.Tag)
+			if ok {
+				t = prev
+			} else {
+				t.Digest = ""
+				t.SyncedAt = time.Time{}
+			}
+			t.LastError = errs[i].Error()
+			t.LastErrorAt = now
+		}
+
+		r.putTag(ctx, t)
+	}
+
+	if job.Repository != nil && job.Repository.Done(firstErr) {
+		r.recordRepository(ctx, job.Repository)
+	}
+}
+
+func (r *runner) putTag(ctx context.Context, t state.Tag) {
+	err := r.state.PutTag(ctx, t)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to record state of tag", "registry", t.Registry, "repository", t.Repository, "tag", t.Tag, "error", err)
+		errorsTotal.WithLabelValues(t.Registry).Inc()
+	}
+}
+
+func (r *runner) putTags(ctx context.Context, tags []state.Tag) {
+	if len(tags) == 0 {
+		return
+	}
+
+	err := r.state.PutTags(ctx, tags)
+	if err != nil {
+		r.logger.LogCtx(ctx, "level", "error", "message", "failed to record state of tags", "registry", tags[0].Registry, "repository", tags[0].Repository, "tags", len(tags), "error", err)
+		errorsTotal.WithLabelValues(tags[0].Registry).Inc()
+	}
+}
+
+type stateResponse struct {
+	Repository *state.Repository `json:"repository"`
+	Tags       []state.Tag       `json:"tags"`
+}
+
+// serveState responds with the recorded state of the source repository given
+// by the repository query parameter and its tags in all destinations. The
+// optional tag query parameter limits the response to a single source tag.
+func (r *runner) serveState(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	repo := req.URL.Query().Get("repository")
+	tag := req.URL.Query().Get("tag")
+	if repo == "" {
+		http.Error(w, "repository query parameter must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	res := stateResponse{
+		Tags: []state.Tag{},
+	}
+
+	s, ok, err := r.state.GetRepository(ctx, r.flag.config.Source.Name, repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		res.Repository = &s
+	}
+
+	for _, d := range r.flag.config.Destinations {
+		dstRepo := d.Mapping.Repository(repo)
+
+		if tag != "" {
+			t, ok, err := r.state.GetTag(ctx, d.Name, dstRepo, d.Mapping.Tag(tag))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if ok {
+				res.Tags = append(res.Tags, t)
+			}
+			continue
+		}
+
+		tags, err := r.state.Tags(ctx, d.Name, dstRepo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Tags = append(res.Tags, tags...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,251 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	jobCompareTags = "compareTags"
+	jobCopyTag     = "copyTag"
+)
+
+// status tracks logins, running jobs and iterations so health, readiness and
+// progress can be served while syncing.
+type status struct {
+	mutex sync.Mutex
+	// registries are names of all registries which must be logged in for
+	// the sync to be ready.
+	registries []string
+	// loggedIn records whether the last login to the registry succeeded.
+	loggedIn map[string]bool
+	// quiet records registries left out of the current iteration because
+	// of quiet windows. They are not logged in so they do not affect
+	// readiness.
+	quiet     map[string]bool
+	nextJobID uint64
+	jobs      map[uint64]statusJob
+	current   *iterationStatus
+	last      *iterationStatus
+}
+
+// statusJob is a job being processed by a worker.
+type statusJob struct {
+	Kind       string    `json:"kind"`
+	Registry   string    `json:"registry"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+}
+
+type iterationStatus struct {
+	Trigger    string     `json:"trigger"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	// Interrupted is true when the iteration was stopped by a termination
+	// signal.
+	Interrupted bool   `json:"interrupted,omitempty"`
+	Error       string `json:"error,omitempty"`
+
+	progress
+}
+
+type progress struct {
+	RepositoriesDone  int64 `json:"repositoriesDone"`
+	RepositoriesTotal int64 `json:"repositoriesTotal"`
+	TagsDone          int64 `json:"tagsDone"`
+	TagsTotal         int64 `json:"tagsTotal"`
+}
+
+type statusResponse struct {
+	Ready            bool             `json:"ready"`
+	LoggedIn         map[string]bool  `json:"loggedIn"`
+	Progress         progress         `json:"progress"`
+	InFlight         []statusJob      `json:"inFlight"`
+	CurrentIteration *iterationStatus `json:"currentIteration"`
+	LastIteration    *iterationStatus `json:"lastIteration"`
+	Schedule         *scheduleStatus  `json:"schedule"`
+}
+
+func newStatus(registries []string) *status {
+	return &status{
+		registries: registries,
+		loggedIn:   map[string]bool{},
+		quiet:      map[string]bool{},
+		jobs:       map[uint64]statusJob{},
+	}
+}
+
+// LoggedIn records the result of logging in the registry.
+func (s *status) LoggedIn(registry string, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.loggedIn[registry] = ok
+}
+
+// Quiet records whether the registry is left out of iterations because of
+// a quiet window.
+func (s *status) Quiet(registry string, quiet bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.quiet[registry] = quiet
+}
+
+// StartJob records the job as running. The returned function records it as
+// finished.
+func (s *status) StartJob(job statusJob) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job.StartedAt = time.Now()
+
+	id := s.nextJobID
+	s.nextJobID++
+	s.jobs[id] = job
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		delete(s.jobs, id)
+	}
+}
+
+func (s *status) StartIteration(trigger string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.current = &iterationStatus{
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+	}
+}
+
+// FinishIteration records the result of the current iteration which becomes
+// the last one.
+func (s *status) FinishIteration(p progress, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.current == nil {
+		return
+	}
+
+	now := time.Now()
+
+	it := s.current
+	it.FinishedAt = &now
+	it.Duration = now.Sub(it.StartedAt).Round(time.Millisecond).String()
+	it.progress = p
+	if IsInterrupted(err) {
+		it.Interrupted = true
+	} else if err != nil {
+		it.Error = err.Error()
+	}
+
+	s.last = it
+	s.current = nil
+}
+
+// Ready returns true when the last logins to all registries which are not in
+// quiet windows succeeded and the last iteration did not fail. Otherwise it returns the reason.
+func (s *status) Ready() (bool, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.ready()
+}
+
+func (s *status) ready() (bool, string) {
+	for _, name := range s.registries {
+		if !s.loggedIn[name] && !s.quiet[name] {
+			return false, fmt.Sprintf("not logged in registry %#q", name)
+		}
+	}
+	if s.last != nil && s.last.Error != "" {
+		return false, fmt.Sprintf("last iteration failed with error: %s", s.last.Error)
+	}
+
+	return true, ""
+}
+
+func (s *status) response(p progress) statusResponse {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ready, _ := s.ready()
+
+	res := statusResponse{
+		Ready:    ready,
+		LoggedIn: map[string]bool{},
+		Progress: p,
+		InFlight: []statusJob{},
+	}
+	for _, name := range s.registries {
+		res.LoggedIn[name] = s.loggedIn[name]
+	}
+	for _, j := range s.jobs {
+		res.InFlight = append(res.InFlight, j)
+	}
+	sort.Slice(res.InFlight, func(i, j int) bool {
+		return res.InFlight[i].StartedAt.Before(res.InFlight[j].StartedAt)
+	})
+	if s.current != nil {
+		current := *s.current
+		current.progress = p
+		res.CurrentIteration = &current
+	}
+	if s.last != nil {
+		last := *s.last
+		res.LastIteration = &last
+	}
+
+	return res
+}
+
+// progress returns progress counters of the current iteration.
+func (r *runner) progress() progress {
+	return progress{
+		RepositoriesDone:  atomic.LoadInt64(&r.progressReposDone),
+		RepositoriesTotal: atomic.LoadInt64(&r.progressReposTotal),
+		TagsDone:          atomic.LoadInt64(&r.progressTagsDone),
+		TagsTotal:         atomic.LoadInt64(&r.progressTagsTotal),
+	}
+}
+
+// serveHealthz responds with 200 as long as the process serves requests.
+func (r *runner) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// serveReadyz responds with 200 when logins to all registries succeeded and
+// the last iteration did not fail and with 503 together with the reason
+// otherwise.
+func (r *runner) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	ready, reason := r.status.Ready()
+	if !ready {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// serveStatus responds with progress of the current iteration, running jobs,
+// the summary of the last iteration and the state of the scheduler.
+func (r *runner) serveStatus(w http.ResponseWriter, req *http.Request) {
+	res := r.status.response(r.progress())
+	s := r.scheduler.Status(time.Now())
+	res.Schedule = &s
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -1,22 +1,143 @@
 package sync
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/giantswarm/crsync/pkg/config"
 	"github.com/giantswarm/crsync/pkg/registry"
+	"github.com/giantswarm/crsync/pkg/state"
 )
 
+// destination is a destination registry together with the mapping of source
+// repository and tag names to the destination ones.
+type destination struct {
+	Registry registry.Interface
+	Mapping  config.Mapping
+	Mirror   config.Mirror
+}
+
+// target is the repository and tag a single source tag is copied to in the
+// destination registry.
+type target struct {
+	Registry registry.Interface
+	Repo     string
+	Tag      string
+	// Drifted is true when the tag exists in the destination registry with
+	// a different digest.
+	Drifted bool
+	// Digest is the digest of the drifted tag in the destination registry.
+	Digest digest.Digest
+}
+
 type getTagsJob struct {
-	Src registry.Interface
-	Dst registry.Interface
+	Src  registry.Interface
+	Dsts []destination
 
-	ID   string
 	Repo string
+	// Full is true in full syncs which compare all the tags even when the
+	// repository did not change since it was synced.
+	Full bool
+	// LastModified is when the source repository was last modified as
+	// reported when listing repositories in this iteration. It is zero when
+	// the registry does not report it or the repository was not listed.
+	LastModified time.Time
 }
 
 type retagJob struct {
-	Src registry.Interface
-	Dst registry.Interface
+	Src  registry.Interface
+	Dsts []target
 
-	ID   string
 	Repo string
 	Tag  string
+	// Digest is the digest of the tag in the source registry when it was
+	// compared with destinations.
+	Digest digest.Digest
+	// Repository tracks the sync of the whole source repository.
+	Repository *repositorySync
+}
+
+type registryCredentials struct {
+	User     string
+	Password string
+}
+
+// digestCache memoizes tag digests of a single repository so they are
+// fetched once even when compared with multiple destinations.
+type digestCache struct {
+	registry registry.Interface
+	repo     string
+
+	mutex   sync.Mutex
+	digests map[string]digest.Digest
+}
+
+func newDigestCache(reg registry.Interface, repo string) *digestCache {
+	return &digestCache{
+		registry: reg,
+		repo:     repo,
+
+		digests: map[string]digest.Digest{},
+	}
+}
+
+func (c *digestCache) Get(ctx context.Context, tag string) (digest.Digest, error) {
+	c.mutex.Lock()
+	d, ok := c.digests[tag]
+	c.mutex.Unlock()
+	if ok {
+		return d, nil
+	}
+
+	d, err := c.registry.Digest(ctx, c.repo, tag)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	c.mutex.Lock()
+	c.digests[tag] = d
+	c.mutex.Unlock()
+
+	return d, nil
+}
+
+// Cached returns the digest of the tag when it was already fetched.
+func (c *digestCache) Cached(tag string) (digest.Digest, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	d, ok := c.digests[tag]
+	return d, ok
+}
+
+// repositorySync tracks retag jobs of a single source repository so its
+// state is recorded once all of them are done.
+type repositorySync struct {
+	mutex     sync.Mutex
+	remaining int
+	// err is the first error which occurred while syncing the repository.
+	err error
+	// incomplete is true when the repository was synced without errors but
+	// some changes were postponed, e.g. deletions waiting for the grace
+	// period.
+	incomplete bool
+	repository state.Repository
+}
+
+// Done marks a single retag job of the repository as done. It returns true
+// when it was the last one.
+func (s *repositorySync) Done(err error) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.remaining--
+
+	return s.remaining == 0
 }
@@ -0,0 +1,195 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	verifyStatusMissing      = "missing"
+	verifyStatusMismatch     = "mismatch"
+	verifyStatusMissingBlobs = "missing-blobs"
+	verifyStatusError        = "error"
+)
+
+// verifyEntry is a single inconsistency between the source and a destination
+// registry found by verify.
+type verifyEntry struct {
+	Registry         string          `json:"registry,omitempty"`
+	Repository       string          `json:"repository,omitempty"`
+	Tag              string          `json:"tag,omitempty"`
+	SourceRepository string          `json:"sourceRepository"`
+	SourceTag        string          `json:"sourceTag,omitempty"`
+	Status           string          `json:"status"`
+	SourceDigest     digest.Digest   `json:"sourceDigest,omitempty"`
+	Digest           digest.Digest   `json:"digest,omitempty"`
+	MissingBlobs     []digest.Digest `json:"missingBlobs,omitempty"`
+	Error            string          `json:"error,omitempty"`
+}
+
+type verifySummary struct {
+	Repositories int `json:"repositories"`
+	// Tags is the number of source tags checked in all destinations.
+	Tags         int `json:"tags"`
+	OK           int `json:"ok"`
+	Missing      int `json:"missing"`
+	Mismatch     int `json:"mismatch"`
+	MissingBlobs int `json:"missingBlobs"`
+	Errors       int `json:"errors"`
+}
+
+// verification collects results of comparing the source registry with
+// destinations instead of syncing them.
+type verification struct {
+	mutex        sync.Mutex
+	repositories int
+	ok           int
+	entries      []verifyEntry
+}
+
+func (v *verification) Add(e verifyEntry) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.entries = append(v.entries, e)
+}
+
+// AddOK records tags found consistent.
+func (v *verification) AddOK(n int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.ok += n
+}
+
+// AddRepository records a verified repository.
+func (v *verification) AddRepository() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.repositories++
+}
+
+// Failed returns true when any inconsistency or error was found.
+func (v *verification) Failed() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return len(v.entries) > 0
+}
+
+func (v *verification) Summary() verifySummary {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	s := verifySummary{
+		Repositories: v.repositories,
+		OK:           v.ok,
+	}
+	for _, e := range v.entries {
+		switch e.Status {
+		case verifyStatusMissing:
+			s.Missing++
+		case verifyStatusMismatch:
+			s.Mismatch++
+		case verifyStatusMissingBlobs:
+			s.MissingBlobs++
+		case verifyStatusError:
+			s.Errors++
+		}
+	}
+	s.Tags = s.OK + s.Missing + s.Mismatch + s.MissingBlobs
+
+	return s
+}
+
+// Write renders the report in the given output format.
+func (v *verification) Write(w io.Writer, output string) error {
+	v.mutex.Lock()
+	entries := append([]verifyEntry(nil), v.entries...)
+	v.mutex.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.SourceRepository != b.SourceRepository {
+			return a.SourceRepository < b.SourceRepository
+		}
+		if a.SourceTag != b.SourceTag {
+			return a.SourceTag < b.SourceTag
+		}
+		return a.Registry < b.Registry
+	})
+
+	summary := v.Summary()
+
+	switch output {
+	case outputJSON:
+		type report struct {
+			Entries []verifyEntry `json:"entries"`
+			Summary verifySummary `json:"summary"`
+		}
+
+		if entries == nil {
+			entries = []verifyEntry{}
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		err := encoder.Encode(report{Entries: entries, Summary: summary})
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(tw, "SOURCE\tREGISTRY\tDESTINATION\tSTATUS\tDETAILS")
+		for _, e := range entries {
+			source := e.SourceRepository
+			if e.SourceTag != "" {
+				source = fmt.Sprintf("%s:%s", e.SourceRepository, e.SourceTag)
+			}
+			destination := "-"
+			if e.Repository != "" {
+				destination = fmt.Sprintf("%s:%s", e.Repository, e.Tag)
+			}
+			registry := "-"
+			if e.Registry != "" {
+				registry = e.Registry
+			}
+
+			var details string
+			switch e.Status {
+			case verifyStatusMismatch:
+				details = fmt.Sprintf("%s != %s", e.SourceDigest, e.Digest)
+			case verifyStatusMissingBlobs:
+				var blobs []string
+				for _, d := range e.MissingBlobs {
+					blobs = append(blobs, d.String())
+				}
+				details = strings.Join(blobs, ",")
+			case verifyStatusError:
+				details = e.Error
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", source, registry, destination, e.Status, details)
+		}
+
+		err := tw.Flush()
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		fmt.Fprintf(w, "\nVerified %d tags in %d repositories: %d ok, %d missing, %d mismatched, %d with missing blobs, %d errors.\n", summary.Tags, summary.Repositories, summary.OK, summary.Missing, summary.Mismatch, summary.MissingBlobs, summary.Errors)
+	}
+
+	return nil
+}
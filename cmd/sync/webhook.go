@@ -0,0 +1,298 @@
+package sync
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	webhookPath = "/webhook"
+	// maxWebhookBodySize limits the size of accepted notifications.
+	maxWebhookBodySize = 1 << 20
+
+	webhookTypeCloudEvents = "cloudevents"
+	webhookTypeDockerHub   = "dockerhub"
+	webhookTypeQuay        = "quay"
+	webhookTypeUnknown     = "unknown"
+
+	webhookStatusIgnored      = "ignored"
+	webhookStatusInvalid      = "invalid"
+	webhookStatusQueued       = "queued"
+	webhookStatusUnauthorized = "unauthorized"
+)
+
+// webhookEvent is a push notification of a source repository.
+type webhookEvent struct {
+	Type       string
+	Repository string
+	Tags       []string
+	// Private is true when the notification states the repository is
+	// private.
+	Private bool
+}
+
+// webhookPayload holds fields of all supported notification formats. Quay
+// sends the repository as a string and Docker Hub as an object so it is
+// decoded later.
+type webhookPayload struct {
+	// CloudEvents structured mode.
+	SpecVersion string          `json:"specversion"`
+	Subject     string          `json:"subject"`
+	Data        json.RawMessage `json:"data"`
+
+	Repository  json.RawMessage `json:"repository"`
+	UpdatedTags []string        `json:"updated_tags"`
+	PushData    *struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+}
+
+type dockerHubRepository struct {
+	RepoName  string `json:"repo_name"`
+	IsPrivate bool   `json:"is_private"`
+}
+
+// cloudEventData is the data of CloudEvents which do not carry the
+// repository in the subject attribute.
+type cloudEventData struct {
+	Repository string   `json:"repository"`
+	Tag        string   `json:"tag"`
+	Tags       []string `json:"tags"`
+}
+
+type webhookResponse struct {
+	Repository string `json:"repository,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// parseWebhookEvent decodes Quay repository push notifications, Docker Hub
+// webhooks and CloudEvents in binary or structured mode.
+func parseWebhookEvent(header http.Header, body []byte) (webhookEvent, error) {
+	// CloudEvents binary mode carries attributes in headers and the data
+	// in the body.
+	if header.Get("Ce-Specversion") != "" {
+		e, err := parseCloudEvent(header.Get("Ce-Subject"), body)
+		if err != nil {
+			return webhookEvent{}, microerror.Mask(err)
+		}
+
+		return e, nil
+	}
+
+	var p webhookPayload
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		return webhookEvent{}, microerror.Maskf(invalidWebhookError, "failed to decode notification: %s", err)
+	}
+
+	if p.SpecVersion != "" {
+		e, err := parseCloudEvent(p.Subject, p.Data)
+		if err != nil {
+			return webhookEvent{}, microerror.Mask(err)
+		}
+
+		return e, nil
+	}
+
+	var repository string
+	if json.Unmarshal(p.Repository, &repository) == nil && repository != "" {
+		return webhookEvent{
+			Type:       webhookTypeQuay,
+			Repository: repository,
+			Tags:       p.UpdatedTags,
+		}, nil
+	}
+
+	var hubRepository dockerHubRepository
+	if json.Unmarshal(p.Repository, &hubRepository) == nil && hubRepository.RepoName != "" {
+		e := webhookEvent{
+			Type:       webhookTypeDockerHub,
+			Repository: hubRepository.RepoName,
+			Private:    hubRepository.IsPrivate,
+		}
+		if p.PushData != nil && p.PushData.Tag != "" {
+			e.Tags = []string{p.PushData.Tag}
+		}
+
+		return e, nil
+	}
+
+	return webhookEvent{}, microerror.Maskf(invalidWebhookError, "notification does not contain repository")
+}
+
+func parseCloudEvent(subject string, data []byte) (webhookEvent, error) {
+	e := webhookEvent{
+		Type:       webhookTypeCloudEvents,
+		Repository: subject,
+	}
+
+	var d cloudEventData
+	if len(data) > 0 && json.Unmarshal(data, &d) == nil {
+		if e.Repository == "" {
+			e.Repository = d.Repository
+		}
+		e.Tags = d.Tags
+		if d.Tag != "" {
+			e.Tags = append(e.Tags, d.Tag)
+		}
+	}
+
+	if e.Repository == "" {
+		return webhookEvent{}, microerror.Maskf(invalidWebhookError, "cloud event does not contain repository in subject or data")
+	}
+
+	return e, nil
+}
+
+// serveWebhook receives push notifications and queues the pushed
+// repositories to be synced. Notifications must carry the configured secret
+// in the X-Webhook-Secret header, as a bearer token or in the secret query
+// parameter for registries which can only be given a URL.
+func (r *runner) serveWebhook(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Responding with an error lets senders retry the notification later.
+	if r.isStopping() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !r.webhookAuthorized(req) {
+		webhookRequestsTotal.WithLabelValues(webhookTypeUnknown, webhookStatusUnauthorized).Inc()
+		writeWebhookResponse(w, http.StatusUnauthorized, webhookResponse{Status: webhookStatusUnauthorized})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxWebhookBodySize))
+	if err != nil {
+		webhookRequestsTotal.WithLabelValues(webhookTypeUnknown, webhookStatusInvalid).Inc()
+		writeWebhookResponse(w, http.StatusBadRequest, webhookResponse{Status: webhookStatusInvalid, Error: err.Error()})
+		return
+	}
+
+	e, err := parseWebhookEvent(req.Header, body)
+	if err != nil {
+		r.logger.LogCtx(req.Context(), "level", "warning", "message", "received invalid webhook", "error", err)
+		webhookRequestsTotal.WithLabelValues(webhookTypeUnknown, webhookStatusInvalid).Inc()
+		writeWebhookResponse(w, http.StatusBadRequest, webhookResponse{Status: webhookStatusInvalid, Error: err.Error()})
+		return
+	}
+
+	if !r.webhookSelects(e) {
+		r.logger.LogCtx(req.Context(), "level", "info", "message", "ignoring webhook of repository not selected for syncing", "type", e.Type, "repository", e.Repository)
+		webhookRequestsTotal.WithLabelValues(e.Type, webhookStatusIgnored).Inc()
+		writeWebhookResponse(w, http.StatusOK, webhookResponse{Repository: e.Repository, Status: webhookStatusIgnored})
+		return
+	}
+
+	r.logger.LogCtx(req.Context(), "level", "info", "message", "queueing repository pushed by webhook", "type", e.Type, "repository", e.Repository, "tags", strings.Join(e.Tags, ","))
+	r.webhooks.Add(e.Repository)
+
+	webhookRequestsTotal.WithLabelValues(e.Type, webhookStatusQueued).Inc()
+	writeWebhookResponse(w, http.StatusAccepted, webhookResponse{Repository: e.Repository, Status: webhookStatusQueued})
+}
+
+func (r *runner) webhookAuthorized(req *http.Request) bool {
+	secret := req.Header.Get("X-Webhook-Secret")
+	if secret == "" {
+		secret = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	}
+	if secret == "" {
+		secret = req.URL.Query().Get("secret")
+	}
+
+	expected := r.flag.config.Webhook.Secret.Value
+
+	return expected != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
+
+// webhookSelects returns true when the pushed repository belongs to one of
+// the source namespaces and is selected for syncing by the configuration.
+func (r *runner) webhookSelects(e webhookEvent) bool {
+	source := r.flag.config.Source
+
+	if e.Private && !source.IncludePrivateRepositories {
+		return false
+	}
+
+	if len(source.Namespaces) > 0 {
+		var found bool
+		for _, n := range source.Namespaces {
+			if strings.HasPrefix(e.Repository, n+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return r.flag.config.Repositories.Selects(e.Repository)
+}
+
+func writeWebhookResponse(w http.ResponseWriter, code int, res webhookResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// webhookQueue holds repositories pushed since they were last taken from
+// the queue. Every repository is queued once.
+type webhookQueue struct {
+	mutex  sync.Mutex
+	repos  []string
+	queued map[string]bool
+	ready  chan struct{}
+}
+
+func newWebhookQueue() *webhookQueue {
+	return &webhookQueue{
+		queued: map[string]bool{},
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+func (q *webhookQueue) Add(repo string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.queued[repo] {
+		return
+	}
+	q.queued[repo] = true
+	q.repos = append(q.repos, repo)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Drain takes all the queued repositories from the queue.
+func (q *webhookQueue) Drain() []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	repos := q.repos
+	q.repos = nil
+	q.queued = map[string]bool{}
+
+	return repos
+}
+
+// Ready is signalled when repositories are added to the queue.
+func (q *webhookQueue) Ready() <-chan struct{} {
+	return q.ready
+}
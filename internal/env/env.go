@@ -4,4 +4,5 @@ const (
 	DstRegistryPassword = "DST_REGISTRY_PASSWORD" // nolint
 	SrcRegistryPassword = "SRC_REGISTRY_PASSWORD" // nolint
 	QuayAPIToken        = "QUAY_API_TOKEN"        // nolint
+	WebhookSecret       = "WEBHOOK_SECRET"        // nolint
 )
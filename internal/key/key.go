@@ -1,5 +0,0 @@
-package key
-
-const (
-	Namespace = "giantswarm"
-)
@@ -9,30 +9,57 @@ import (
 	"net/http"
 
 	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
 
 	"github.com/giantswarm/crsync/pkg/registry"
 )
 
+const (
+	// pageSize is the number of repositories requested per catalog page.
+	pageSize = 100
+)
+
 type Config struct {
+	Logger micrologger.Logger
+
 	RegistryName string
+	// Namespaces optionally limit listed repositories to the ones with one
+	// of the given path prefixes. E.g.: "giantswarm".
+	Namespaces []string
+	// HTTPClient is optional. A new client is used when nil.
+	HTTPClient *http.Client
 }
 
 type AzureCR struct {
+	logger micrologger.Logger
+
 	token            string
+	registryName     string
 	registryEndpoint string
+	namespaces       []string
 
 	httpClient *http.Client
 }
 
 func New(c Config) (*AzureCR, error) {
+	if c.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
 	if c.RegistryName == "" {
 		return nil, microerror.Maskf(invalidConfigError, "%T.RegistryName must not be empty", c)
 	}
 
-	httpClient := &http.Client{}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
 
 	return &AzureCR{
+		logger: c.Logger,
+
+		registryName:     c.RegistryName,
 		registryEndpoint: fmt.Sprintf("https://%s", c.RegistryName),
+		namespaces:       c.Namespaces,
 
 		httpClient: httpClient,
 	}, nil
@@ -48,54 +75,130 @@ func (d *AzureCR) Authorize(ctx context.Context, user, password string) error {
 }
 
 func (d *AzureCR) ListRepositories(ctx context.Context) ([]string, error) {
-	return nil, microerror.Maskf(executionFailedError, "method not implemented")
+	endpoint := fmt.Sprintf("%s/v2/_catalog?n=%d", d.registryEndpoint, pageSize)
+
+	type azureCRCatalog struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	var repoCount int
+	var reposToSync []string
+	{
+		nextEndpoint := endpoint
+
+		for nextEndpoint != "" {
+			var catalogJSON azureCRCatalog
+			var err error
+			nextEndpoint, err = d.getPage(ctx, nextEndpoint, &catalogJSON)
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+
+			for _, repo := range catalogJSON.Repositories {
+				if registry.InNamespaces(repo, d.namespaces) {
+					reposToSync = append(reposToSync, repo)
+				}
+			}
+			repoCount += len(catalogJSON.Repositories)
+		}
+	}
+
+	d.logger.LogCtx(ctx, "level", "debug", "message", "listed repositories", "registry", d.registryName, "repositories", repoCount, "selected", len(reposToSync))
+
+	return reposToSync, nil
 }
 
 func (d *AzureCR) ListTags(ctx context.Context, repository string) ([]string, error) {
 	endpoint := fmt.Sprintf("%s/v2/%s/tags/list", d.registryEndpoint, repository)
 
 	type azureCRTags struct {
-		Tags []string `yaml:"tags"`
+		Tags []string `json:"tags"`
 	}
 
-	var tagsJSON azureCRTags
 	var tags []string
 	{
 		nextEndpoint := endpoint
 
-		for {
-			req, err := http.NewRequest("GET", nextEndpoint, nil)
-			if err != nil {
+		for nextEndpoint != "" {
+			var tagsJSON azureCRTags
+			var err error
+			nextEndpoint, err = d.getPage(ctx, nextEndpoint, &tagsJSON)
+			if IsNotFound(err) {
+				// Repositories which do not exist yet have no tags.
+				return []string{}, nil
+			} else if err != nil {
 				return []string{}, microerror.Mask(err)
 			}
 
-			req.Header.Set("Authorization", fmt.Sprintf("basic %s", d.token))
+			tags = append(tags, tagsJSON.Tags...)
+		}
+	}
 
-			resp, err := d.httpClient.Do(req)
-			if err != nil {
-				return []string{}, microerror.Mask(err)
-			}
+	return tags, nil
+}
 
-			defer resp.Body.Close()
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return []string{}, microerror.Mask(err)
-			}
+// DeleteTag removes the tag with the ACR API. The manifest and other tags
+// referencing it are kept.
+func (d *AzureCR) DeleteTag(ctx context.Context, repository, tag string) error {
+	endpoint := fmt.Sprintf("%s/acr/v1/%s/_tags/%s", d.registryEndpoint, repository, tag)
 
-			err = json.Unmarshal(body, &tagsJSON)
-			if err != nil {
-				return []string{}, microerror.Mask(err)
-			}
-			tags = append(tags, tagsJSON.Tags...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return microerror.Mask(err)
+	}
 
-			linkHeader := resp.Header.Get("Link")
-			if linkHeader == "" {
-				break
-			}
+	req.Header.Set("Authorization", fmt.Sprintf("basic %s", d.token))
 
-			nextEndpoint = fmt.Sprintf("%s%s", d.registryEndpoint, registry.GetLink(linkHeader))
-		}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return microerror.Mask(err)
 	}
+	defer resp.Body.Close()
 
-	return tags, nil
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return microerror.Maskf(executionFailedError, "deleting tag %#q failed with status %d", fmt.Sprintf("%s:%s", repository, tag), resp.StatusCode)
+	}
+
+	return nil
+}
+
+// getPage decodes the page at endpoint into v and returns the endpoint of the
+// next page, which is empty for the last one.
+func (d *AzureCR) getPage(ctx context.Context, endpoint string, v interface{}) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("basic %s", d.token))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", microerror.Maskf(notFoundError, "listing %#q failed with status %d", endpoint, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", microerror.Maskf(executionFailedError, "listing %#q failed with status %d", endpoint, resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	linkHeader := resp.Header.Get("Link")
+	if linkHeader == "" {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%s%s", d.registryEndpoint, registry.GetLink(linkHeader)), nil
 }
@@ -0,0 +1,427 @@
+// Package config implements the declarative sync configuration file.
+//
+// Example:
+//
+//	source:
+//	  name: quay.io
+//	  credentials:
+//	    user: giantswarm+crsync
+//	    password:
+//	      env: SRC_REGISTRY_PASSWORD
+//	  quayAPIToken:
+//	    file: /secrets/quay-api-token
+//	  namespaces:
+//	  - giantswarm
+//	  lastModified: 2h
+//	destinations:
+//	- name: gsoci.azurecr.io
+//	  credentials:
+//	    user: crsync
+//	    password:
+//	      env: DST_REGISTRY_PASSWORD
+//	  mapping:
+//	    repositories:
+//	    - prefix:
+//	        from: giantswarm/
+//	        to: mirror/giantswarm-
+//	  limits:
+//	    concurrency: 20
+//	    push:
+//	      interval: 100ms
+//	      burst: 20
+//	  retry:
+//	    attempts:
+//	      push: 5
+//	    maxBackoff: 1m
+//	  mirror:
+//	    enabled: true
+//	    protectedTags:
+//	    - ^v?[0-9]+\.[0-9]+\.[0-9]+$
+//	    gracePeriod: 24h
+//	repositories:
+//	  exclude:
+//	  - ^giantswarm/test-
+//	  tags:
+//	    exclude:
+//	    - ^pr-
+//	  rules:
+//	  - match: ^giantswarm/app-operator$
+//	    tags:
+//	      semver: ">=1.0.0"
+//	      excludePrereleases: true
+//	state:
+//	  path: /data/state.db
+//	schedule:
+//	  incremental:
+//	    interval: 1m
+//	    jitter: 10s
+//	  full:
+//	    cron: CRON_TZ=Europe/Berlin 0 3 * * *
+//	  quietWindows:
+//	  - destinations:
+//	    - docker.io
+//	    days: [mon, tue, wed, thu, fri]
+//	    start: "09:00"
+//	    end: "18:00"
+//	    timezone: Europe/Berlin
+//	webhook:
+//	  port: 8080
+//	  secret:
+//	    env: WEBHOOK_SECRET
+//	workers:
+//	  tags: 20
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultLastModified = time.Hour
+)
+
+type Config struct {
+	Source       Source        `yaml:"source"`
+	Destinations []Destination `yaml:"destinations"`
+	Repositories Repositories  `yaml:"repositories"`
+	State        State         `yaml:"state"`
+	FullSync     FullSync      `yaml:"fullSync"`
+	Schedule     Schedule      `yaml:"schedule"`
+	Webhook      Webhook       `yaml:"webhook"`
+	Workers      Workers       `yaml:"workers"`
+}
+
+type Registry struct {
+	// Name is the registry host with optional port. E.g.: "quay.io".
+	Name string `yaml:"name"`
+	// Insecure allows talking to the registry over plain HTTP or HTTPS
+	// without TLS verification.
+	Insecure    bool        `yaml:"insecure"`
+	Credentials Credentials `yaml:"credentials"`
+	// Limits configure concurrency and rate limits of operations done in
+	// the registry.
+	Limits Limits `yaml:"limits"`
+	// Retry configures retries of operations failing with transient errors.
+	Retry Retry `yaml:"retry"`
+}
+
+type Destination struct {
+	Registry `yaml:",inline"`
+
+	// Mapping rewrites source repository and tag names when syncing to
+	// this destination.
+	Mapping Mapping `yaml:"mapping"`
+	// Mirror deletes tags no longer existing in the source registry.
+	Mirror Mirror `yaml:"mirror"`
+}
+
+type Source struct {
+	Registry `yaml:",inline"`
+
+	// Namespaces are organizations or users which repositories are synced.
+	Namespaces                 []string      `yaml:"namespaces"`
+	IncludePrivateRepositories bool          `yaml:"includePrivateRepositories"`
+	LastModified               time.Duration `yaml:"lastModified"`
+	QuayAPIToken               Secret        `yaml:"quayAPIToken"`
+}
+
+// State configures where the sync state is persisted.
+type State struct {
+	// Path is the state database file. When empty the state is kept in
+	// memory and lost on restart.
+	Path string `yaml:"path"`
+}
+
+// FullSync configures periodic iterations syncing all the source
+// repositories regardless of source.lastModified. They also compare every
+// tag with destinations instead of skipping repositories and trusting
+// digests recorded in the state so tags lost in destinations are restored.
+type FullSync struct {
+	// Every is the number of incremental iterations after which one is
+	// a full sync. 0 disables full syncs unless schedule.full is set.
+	Every int `yaml:"every"`
+}
+
+// Webhook configures the HTTP endpoint receiving push notifications which
+// trigger immediate sync of pushed repositories.
+type Webhook struct {
+	// Port on which the endpoint is served. 0 disables the endpoint.
+	Port int `yaml:"port"`
+	// Secret must be sent with every notification.
+	Secret Secret `yaml:"secret"`
+}
+
+type Credentials struct {
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
+}
+
+// Secret references a value kept outside of the configuration file. The
+// value is read from the environment variable or the file when the
+// configuration is loaded. Value can be also set directly by flags.
+type Secret struct {
+	Env  string `yaml:"env"`
+	File string `yaml:"file"`
+
+	Value string `yaml:"-"`
+}
+
+type Repositories struct {
+	// Include lists regular expressions matched against source repository
+	// names. When not empty only matching repositories are synced.
+	Include []string `yaml:"include"`
+	// Exclude lists regular expressions matched against source repository
+	// names. Matching repositories are not synced.
+	Exclude []string `yaml:"exclude"`
+	// Tags is the default filter of tags applied to repositories not
+	// matched by any of the rules.
+	Tags TagFilter `yaml:"tags"`
+	// Rules set tag filters for repositories matching their patterns. The
+	// first matching rule is used.
+	Rules []TagRule `yaml:"rules"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Load reads the configuration file and resolves secret references. The file
+// is decoded strictly so unknown fields are reported as errors.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "failed to read config file %#q: %s", path, err)
+	}
+
+	var c Config
+	{
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		err = decoder.Decode(&c)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "failed to parse config file %#q: %s", path, err)
+		}
+	}
+
+	err = c.resolveSecrets()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return &c, nil
+}
+
+// Default fills in default values of fields which were not set.
+func (c *Config) Default() {
+	if c.Source.LastModified == 0 {
+		c.Source.LastModified = defaultLastModified
+	}
+	c.Source.Limits.setDefaults()
+	c.Source.Retry.setDefaults()
+	for i := range c.Destinations {
+		c.Destinations[i].Limits.setDefaults()
+		c.Destinations[i].Retry.setDefaults()
+		c.Destinations[i].Mirror.setDefaults()
+	}
+	c.Workers.setDefaults()
+	c.Schedule.setDefaults()
+}
+
+// Validate checks the configuration after all overrides were applied.
+func (c *Config) Validate() error {
+	err := c.Source.validate("source")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if c.Source.LastModified < 0 {
+		return microerror.Maskf(invalidConfigError, "source.lastModified must not be negative")
+	}
+	for i, n := range c.Source.Namespaces {
+		if n == "" {
+			return microerror.Maskf(invalidConfigError, "source.namespaces[%d] must not be empty", i)
+		}
+	}
+
+	if len(c.Destinations) == 0 {
+		return microerror.Maskf(invalidConfigError, "destinations must not be empty")
+	}
+	for i, d := range c.Destinations {
+		err = d.validate(fmt.Sprintf("destinations[%d]", i))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		err = c.Destinations[i].Mapping.validate(fmt.Sprintf("destinations[%d].mapping", i))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		err = c.Destinations[i].Mirror.validate(fmt.Sprintf("destinations[%d].mirror", i))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		for j, other := range c.Destinations[:i] {
+			if d.Name == other.Name {
+				return microerror.Maskf(invalidConfigError, "destinations[%d].name %#q duplicates destinations[%d].name", i, d.Name, j)
+			}
+		}
+	}
+
+	err = c.Workers.validate("workers")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if c.FullSync.Every < 0 {
+		return microerror.Maskf(invalidConfigError, "fullSync.every must not be negative")
+	}
+	if c.FullSync.Every != 0 && c.Schedule.Full.Enabled() {
+		return microerror.Maskf(invalidConfigError, "fullSync.every and schedule.full must not be set together")
+	}
+	err = c.Schedule.validate("schedule", c.Destinations)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if c.Webhook.Port < 0 || c.Webhook.Port > 65535 {
+		return microerror.Maskf(invalidConfigError, "webhook.port must be between 0 and 65535")
+	}
+	if c.Webhook.Port != 0 && c.Webhook.Secret.Value == "" {
+		return microerror.Maskf(invalidConfigError, "webhook.secret must not be empty when webhook.port is set")
+	}
+
+	c.Repositories.include, err = compilePatterns("repositories.include", c.Repositories.Include)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	c.Repositories.exclude, err = compilePatterns("repositories.exclude", c.Repositories.Exclude)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = c.Repositories.Tags.validate("repositories.tags")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	for i := range c.Repositories.Rules {
+		err = c.Repositories.Rules[i].validate(fmt.Sprintf("repositories.rules[%d]", i))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// Selects returns true when the source repository is selected for syncing by
+// the repositories section. It must be called after Validate.
+func (r Repositories) Selects(repository string) bool {
+	for _, re := range r.exclude {
+		if re.MatchString(repository) {
+			return false
+		}
+	}
+
+	if len(r.include) == 0 {
+		return true
+	}
+	for _, re := range r.include {
+		if re.MatchString(repository) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Config) resolveSecrets() error {
+	var err error
+
+	err = c.Source.Credentials.Password.resolve("source.credentials.password")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = c.Source.QuayAPIToken.resolve("source.quayAPIToken")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = c.Webhook.Secret.resolve("webhook.secret")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	for i := range c.Destinations {
+		err = c.Destinations[i].Credentials.Password.resolve(fmt.Sprintf("destinations[%d].credentials.password", i))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (r Registry) validate(path string) error {
+	if r.Name == "" {
+		return microerror.Maskf(invalidConfigError, "%s.name must not be empty", path)
+	}
+	if r.Credentials.User == "" {
+		return microerror.Maskf(invalidConfigError, "%s.credentials.user must not be empty", path)
+	}
+	if r.Credentials.Password.Value == "" {
+		return microerror.Maskf(invalidConfigError, "%s.credentials.password must not be empty", path)
+	}
+	err := r.Limits.validate(fmt.Sprintf("%s.limits", path))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = r.Retry.validate(fmt.Sprintf("%s.retry", path))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (s *Secret) resolve(path string) error {
+	if s.Env != "" && s.File != "" {
+		return microerror.Maskf(invalidConfigError, "%s.env and %s.file must not be set at the same time", path, path)
+	}
+
+	if s.Env != "" {
+		s.Value = os.Getenv(s.Env)
+		if s.Value == "" {
+			return microerror.Maskf(invalidConfigError, "%s.env references empty environment variable %#q", path, s.Env)
+		}
+	}
+
+	if s.File != "" {
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return microerror.Maskf(invalidConfigError, "%s.file can not be read: %s", path, err)
+		}
+		s.Value = strings.TrimSpace(string(data))
+	}
+
+	return nil
+}
+
+func compilePatterns(path string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "%s[%d] is not a valid regular expression: %s", path, i, err)
+		}
+
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	defaultRepositoryWorkers = 100
+	defaultTagWorkers        = 10
+)
+
+// Workers configures how many jobs run in parallel.
+type Workers struct {
+	// Repositories is the number of repositories which tags are listed and
+	// compared in parallel. Defaults to 100.
+	Repositories int `yaml:"repositories"`
+	// Tags is the number of tags copied in parallel. Defaults to 10.
+	Tags int `yaml:"tags"`
+}
+
+// Limits configures how hard a registry is used.
+type Limits struct {
+	// Concurrency limits the number of images pulled from or pushed to the
+	// registry at the same time. 0 means it is only limited by workers.
+	Concurrency int `yaml:"concurrency"`
+
+	ListRepositories RateLimit `yaml:"listRepositories"`
+	ListTags         RateLimit `yaml:"listTags"`
+	Digest           RateLimit `yaml:"digest"`
+	Pull             RateLimit `yaml:"pull"`
+	Push             RateLimit `yaml:"push"`
+	Delete           RateLimit `yaml:"delete"`
+}
+
+// RateLimit limits the rate of a single registry operation.
+type RateLimit struct {
+	// Interval is the minimum average time between two operations. 0
+	// disables the limit, e.g. for a local registry.
+	Interval *time.Duration `yaml:"interval"`
+	// Burst is the number of operations which can be done at once.
+	Burst int `yaml:"burst"`
+}
+
+func (r RateLimit) String() string {
+	if r.Interval == nil {
+		return fmt.Sprintf("default burst %d", r.Burst)
+	}
+	if *r.Interval == 0 {
+		return "unlimited"
+	}
+
+	return fmt.Sprintf("every %s burst %d", *r.Interval, r.Burst)
+}
+
+// RateLimit returns the rate limit of the operation given by its name used
+// in the configuration file, e.g. "listTags".
+func (l *Limits) RateLimit(operation string) (*RateLimit, error) {
+	switch operation {
+	case "listRepositories":
+		return &l.ListRepositories, nil
+	case "listTags":
+		return &l.ListTags, nil
+	case "digest":
+		return &l.Digest, nil
+	case "pull":
+		return &l.Pull, nil
+	case "push":
+		return &l.Push, nil
+	case "delete":
+		return &l.Delete, nil
+	}
+
+	return nil, microerror.Maskf(invalidConfigError, "unknown operation %#q, it must be one of %#q", operation, []string{"listRepositories", "listTags", "digest", "pull", "push", "delete"})
+}
+
+func (w *Workers) setDefaults() {
+	if w.Repositories == 0 {
+		w.Repositories = defaultRepositoryWorkers
+	}
+	if w.Tags == 0 {
+		w.Tags = defaultTagWorkers
+	}
+}
+
+func (w Workers) validate(path string) error {
+	if w.Repositories < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.repositories must not be negative", path)
+	}
+	if w.Tags < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.tags must not be negative", path)
+	}
+
+	return nil
+}
+
+func (l *Limits) setDefaults() {
+	l.ListRepositories.setDefaults(5*time.Second, 1)
+	l.ListTags.setDefaults(1*time.Second, 1)
+	l.Digest.setDefaults(100*time.Millisecond, 10)
+	l.Pull.setDefaults(1*time.Second, 10)
+	l.Push.setDefaults(1*time.Second, 10)
+	l.Delete.setDefaults(1*time.Second, 1)
+}
+
+func (l Limits) validate(path string) error {
+	if l.Concurrency < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.concurrency must not be negative", path)
+	}
+
+	rateLimits := []struct {
+		name string
+		r    RateLimit
+	}{
+		{"listRepositories", l.ListRepositories},
+		{"listTags", l.ListTags},
+		{"digest", l.Digest},
+		{"pull", l.Pull},
+		{"push", l.Push},
+		{"delete", l.Delete},
+	}
+	for _, r := range rateLimits {
+		if r.r.Interval != nil && *r.r.Interval < 0 {
+			return microerror.Maskf(invalidConfigError, "%s.%s.interval must not be negative", path, r.name)
+		}
+		if r.r.Burst < 0 {
+			return microerror.Maskf(invalidConfigError, "%s.%s.burst must not be negative", path, r.name)
+		}
+	}
+
+	return nil
+}
+
+func (r *RateLimit) setDefaults(interval time.Duration, burst int) {
+	if r.Interval == nil {
+		r.Interval = &interval
+	}
+	if r.Burst == 0 {
+		r.Burst = burst
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+)
+
+// Mapping rewrites source repository and tag names to destination ones. For
+// every name the first matching rule is applied. Names not matching any rule
+// are kept as they are.
+type Mapping struct {
+	Repositories []MappingRule `yaml:"repositories"`
+	Tags         []MappingRule `yaml:"tags"`
+}
+
+// MappingRule must set exactly one of its fields.
+type MappingRule struct {
+	// Prefix replaces the name prefix.
+	Prefix *PrefixMapping `yaml:"prefix"`
+	// Regex replaces names matching the regular expression. The
+	// replacement can reference submatches like "$1".
+	Regex *RegexMapping `yaml:"regex"`
+	// Map replaces names equal to its keys with their values.
+	Map map[string]string `yaml:"map"`
+}
+
+type PrefixMapping struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+type RegexMapping struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+
+	match *regexp.Regexp
+}
+
+// Repository returns the destination name of the source repository. It must
+// be called after Validate.
+func (m Mapping) Repository(repository string) string {
+	return mapName(m.Repositories, repository)
+}
+
+// Tag returns the destination name of the source tag. It must be called
+// after Validate.
+func (m Mapping) Tag(tag string) string {
+	return mapName(m.Tags, tag)
+}
+
+func (m *Mapping) validate(path string) error {
+	for i := range m.Repositories {
+		err := m.Repositories[i].validate(fmt.Sprintf("%s.repositories[%d]", path, i))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+	for i := range m.Tags {
+		err := m.Tags[i].validate(fmt.Sprintf("%s.tags[%d]", path, i))
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (r *MappingRule) validate(path string) error {
+	var set int
+	if r.Prefix != nil {
+		set++
+	}
+	if r.Regex != nil {
+		set++
+	}
+	if r.Map != nil {
+		set++
+	}
+	if set != 1 {
+		return microerror.Maskf(invalidConfigError, "%s must set exactly one of prefix, regex or map", path)
+	}
+
+	if r.Prefix != nil && r.Prefix.From == "" {
+		return microerror.Maskf(invalidConfigError, "%s.prefix.from must not be empty", path)
+	}
+
+	if r.Regex != nil {
+		var err error
+
+		if r.Regex.Match == "" {
+			return microerror.Maskf(invalidConfigError, "%s.regex.match must not be empty", path)
+		}
+		r.Regex.match, err = regexp.Compile(r.Regex.Match)
+		if err != nil {
+			return microerror.Maskf(invalidConfigError, "%s.regex.match is not a valid regular expression: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+func mapName(rules []MappingRule, name string) string {
+	for _, r := range rules {
+		switch {
+		case r.Prefix != nil:
+			if strings.HasPrefix(name, r.Prefix.From) {
+				return r.Prefix.To + strings.TrimPrefix(name, r.Prefix.From)
+			}
+		case r.Regex != nil:
+			if r.Regex.match.MatchString(name) {
+				return r.Regex.match.ReplaceAllString(name, r.Regex.Replace)
+			}
+		case r.Map != nil:
+			if mapped, ok := r.Map[name]; ok {
+				return mapped
+			}
+		}
+	}
+
+	return name
+}
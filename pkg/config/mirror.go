@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	defaultMirrorMaxDeletions        = 100
+	defaultMirrorMaxDeletionsPercent = 20
+	// mirrorMinTagsForMaxDeletionsPercent is the number of destination
+	// tags below which repositories are not limited by
+	// maxDeletionsPercent, because deleting a single tag of a small
+	// repository easily exceeds any reasonable percentage.
+	mirrorMinTagsForMaxDeletionsPercent = 10
+)
+
+// Mirror configures deleting destination tags which no longer exist in the
+// source repository.
+type Mirror struct {
+	// Enabled turns on deleting tags absent in the source repository from
+	// the destination repository.
+	Enabled bool `yaml:"enabled"`
+	// DryRun only reports tags which would be deleted.
+	DryRun bool `yaml:"dryRun"`
+	// MaxDeletions limits the number of tags deleted from the destination
+	// registry in a single sync iteration. Defaults to 100.
+	MaxDeletions int `yaml:"maxDeletions"`
+	// MaxDeletionsPercent skips deleting tags of a repository when more
+	// than the given percentage of its destination tags would be deleted.
+	// Repositories with less than 10 destination tags are not limited.
+	// Defaults to 20. 0 disables the limit.
+	MaxDeletionsPercent *int `yaml:"maxDeletionsPercent"`
+	// ProtectedTags lists regular expressions matched against destination
+	// tag names. Matching tags are never deleted.
+	ProtectedTags []string `yaml:"protectedTags"`
+	// GracePeriod is the time a tag must be absent in the source
+	// repository before it is deleted from the destination one.
+	GracePeriod time.Duration `yaml:"gracePeriod"`
+
+	protectedTags []*regexp.Regexp
+}
+
+// Protects returns true when the destination tag must never be deleted. It
+// must be called after Validate.
+func (m Mirror) Protects(tag string) bool {
+	for _, re := range m.protectedTags {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExceedsMaxDeletionsPercent returns true when deleting n of the existing
+// destination tags of a repository exceeds MaxDeletionsPercent. It must be
+// called after Validate.
+func (m Mirror) ExceedsMaxDeletionsPercent(n, existing int) bool {
+	p := *m.MaxDeletionsPercent
+	if p == 0 || existing < mirrorMinTagsForMaxDeletionsPercent {
+		return false
+	}
+
+	return n*100 > p*existing
+}
+
+func (m *Mirror) setDefaults() {
+	if m.MaxDeletions == 0 {
+		m.MaxDeletions = defaultMirrorMaxDeletions
+	}
+	if m.MaxDeletionsPercent == nil {
+		p := defaultMirrorMaxDeletionsPercent
+		m.MaxDeletionsPercent = &p
+	}
+}
+
+func (m *Mirror) validate(path string) error {
+	var err error
+
+	if m.MaxDeletions < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.maxDeletions must not be negative", path)
+	}
+	if p := m.MaxDeletionsPercent; p != nil && (*p < 0 || *p > 100) {
+		return microerror.Maskf(invalidConfigError, "%s.maxDeletionsPercent must be between 0 and 100", path)
+	}
+	if m.GracePeriod < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.gracePeriod must not be negative", path)
+	}
+
+	m.protectedTags, err = compilePatterns(fmt.Sprintf("%s.protectedTags", path), m.ProtectedTags)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
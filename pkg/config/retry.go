@@ -0,0 +1,91 @@
+package config
+
+import (
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+)
+
+// Retry configures retries of registry operations failing with transient
+// errors like 5xx, 429, timeouts or broken connections.
+type Retry struct {
+	// Attempts caps the number of attempts per operation. 1 disables
+	// retries. Defaults to 3.
+	Attempts RetryAttempts `yaml:"attempts"`
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry up to MaxBackoff and is randomized. Defaults to 1s.
+	Backoff time.Duration `yaml:"backoff"`
+	// MaxBackoff is the longest delay between retries. Defaults to 30s.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+type RetryAttempts struct {
+	ListRepositories int `yaml:"listRepositories"`
+	ListTags         int `yaml:"listTags"`
+	Digest           int `yaml:"digest"`
+	Pull             int `yaml:"pull"`
+	Push             int `yaml:"push"`
+	Delete           int `yaml:"delete"`
+}
+
+// Set sets attempts of all operations.
+func (a *RetryAttempts) Set(attempts int) {
+	a.ListRepositories = attempts
+	a.ListTags = attempts
+	a.Digest = attempts
+	a.Pull = attempts
+	a.Push = attempts
+	a.Delete = attempts
+}
+
+func (r *Retry) setDefaults() {
+	for _, a := range r.Attempts.all() {
+		if *a.attempts == 0 {
+			*a.attempts = defaultRetryAttempts
+		}
+	}
+	if r.Backoff == 0 {
+		r.Backoff = defaultRetryBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = defaultRetryMaxBackoff
+	}
+}
+
+func (r Retry) validate(path string) error {
+	for _, a := range r.Attempts.all() {
+		if *a.attempts < 0 {
+			return microerror.Maskf(invalidConfigError, "%s.attempts.%s must not be negative", path, a.name)
+		}
+	}
+	if r.Backoff < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.backoff must not be negative", path)
+	}
+	if r.MaxBackoff < r.Backoff {
+		return microerror.Maskf(invalidConfigError, "%s.maxBackoff must not be shorter than %s.backoff", path, path)
+	}
+
+	return nil
+}
+
+type namedAttempts struct {
+	name     string
+	attempts *int
+}
+
+func (a *RetryAttempts) all() []namedAttempts {
+	return []namedAttempts{
+		{"listRepositories", &a.ListRepositories},
+		{"listTags", &a.ListTags},
+		{"digest", &a.Digest},
+		{"pull", &a.Pull},
+		{"push", &a.Push},
+		{"delete", &a.Delete},
+	}
+}
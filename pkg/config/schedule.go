@@ -0,0 +1,258 @@
+package config
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	// Time zones of quiet windows are resolved also in images without
+	// the time zone database.
+	_ "time/tzdata"
+
+	"github.com/giantswarm/microerror"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultSyncInterval = 30 * time.Second
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule configures when iterations start when running in a loop.
+type Schedule struct {
+	// Incremental starts iterations syncing repositories modified within
+	// source.lastModified and repositories which last sync failed.
+	// Defaults to an interval of 30s.
+	Incremental Recurrence `yaml:"incremental"`
+	// Full starts full syncs. It can not be set together with
+	// fullSync.every.
+	Full Recurrence `yaml:"full"`
+	// QuietWindows are periods in which nothing is pushed to destination
+	// registries.
+	QuietWindows []QuietWindow `yaml:"quietWindows"`
+}
+
+// Recurrence is either a cron expression or an interval between the end of
+// an iteration and the start of the next one.
+type Recurrence struct {
+	// Cron is a standard cron expression with five fields or a descriptor
+	// like "@daily". It is evaluated in UTC unless prefixed with
+	// "CRON_TZ=<timezone> ".
+	Cron string `yaml:"cron"`
+	// Interval is the time between the end of an iteration and the start
+	// of the next one.
+	Interval time.Duration `yaml:"interval"`
+	// Jitter delays every iteration by a random duration up to the given
+	// one so multiple instances do not hit registries at the same time.
+	Jitter time.Duration `yaml:"jitter"`
+
+	cron cron.Schedule
+}
+
+// QuietWindow is a recurring period of the day in which nothing is pushed to
+// the destination registries, e.g. to keep Docker Hub quota for business
+// hours.
+type QuietWindow struct {
+	// Destinations are names of destination registries the window applies
+	// to. When empty it applies to all of them.
+	Destinations []string `yaml:"destinations"`
+	// Days are weekdays the window starts on, e.g. "mon". When empty the
+	// window starts every day.
+	Days []string `yaml:"days"`
+	// Start and End are times of day in "15:04" format. A window ending
+	// before it starts spans midnight.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Timezone is the IANA name of the time zone of Start and End, e.g.
+	// "Europe/Berlin". Defaults to UTC.
+	Timezone string `yaml:"timezone"`
+
+	days     map[time.Weekday]bool
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+// Enabled returns true when a cron expression or an interval is set.
+func (r Recurrence) Enabled() bool {
+	return r.Cron != "" || r.Interval > 0
+}
+
+// Next returns the time of the iteration following the one which finished at
+// t. It must be called after Validate.
+func (r Recurrence) Next(t time.Time) time.Time {
+	next := t.Add(r.Interval)
+	if r.cron != nil {
+		next = r.cron.Next(t)
+	}
+	if r.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(r.Jitter))))
+	}
+
+	return next
+}
+
+// Quiet returns true when nothing must be pushed to the destination at t. It
+// must be called after Validate.
+func (s Schedule) Quiet(destination string, t time.Time) bool {
+	for _, w := range s.QuietWindows {
+		if w.Applies(destination) && w.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Applies returns true when the window applies to the destination.
+func (w QuietWindow) Applies(destination string) bool {
+	if len(w.Destinations) == 0 {
+		return true
+	}
+	for _, d := range w.Destinations {
+		if d == destination {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains returns true when t is within the window. It must be called after
+// Validate.
+func (w QuietWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.start < w.end {
+		return w.startsOn(t.Weekday()) && sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+
+	// The window spans midnight. It either started today or yesterday.
+	if w.startsOn(t.Weekday()) && sinceMidnight >= w.start {
+		return true
+	}
+
+	return w.startsOn(t.AddDate(0, 0, -1).Weekday()) && sinceMidnight < w.end
+}
+
+func (w QuietWindow) startsOn(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+func (s *Schedule) setDefaults() {
+	if !s.Incremental.Enabled() {
+		s.Incremental.Interval = defaultSyncInterval
+	}
+}
+
+func (s *Schedule) validate(path string, destinations []Destination) error {
+	var err error
+
+	err = s.Incremental.validate(fmt.Sprintf("%s.incremental", path))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	err = s.Full.validate(fmt.Sprintf("%s.full", path))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	for i := range s.QuietWindows {
+		err = s.QuietWindows[i].validate(fmt.Sprintf("%s.quietWindows[%d]", path, i), destinations)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Recurrence) validate(path string) error {
+	var err error
+
+	if r.Cron != "" && r.Interval != 0 {
+		return microerror.Maskf(invalidConfigError, "%s.cron and %s.interval must not be set together", path, path)
+	}
+	if r.Interval < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.interval must not be negative", path)
+	}
+	if r.Jitter < 0 {
+		return microerror.Maskf(invalidConfigError, "%s.jitter must not be negative", path)
+	}
+
+	if r.Cron != "" {
+		r.cron, err = cron.ParseStandard(r.Cron)
+		if err != nil {
+			return microerror.Maskf(invalidConfigError, "%s.cron %#q is invalid: %s", path, r.Cron, err)
+		}
+	}
+
+	return nil
+}
+
+func (w *QuietWindow) validate(path string, destinations []Destination) error {
+	var err error
+
+	for i, name := range w.Destinations {
+		var found bool
+		for _, d := range destinations {
+			if d.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return microerror.Maskf(invalidConfigError, "%s.destinations[%d] %#q must be one of destinations", path, i, name)
+		}
+	}
+
+	w.days = map[time.Weekday]bool{}
+	for i, d := range w.Days {
+		day, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return microerror.Maskf(invalidConfigError, "%s.days[%d] %#q must be one of %#q, %#q, %#q, %#q, %#q, %#q or %#q", path, i, d, "mon", "tue", "wed", "thu", "fri", "sat", "sun")
+		}
+		w.days[day] = true
+	}
+
+	w.start, err = parseTimeOfDay(w.Start)
+	if err != nil {
+		return microerror.Maskf(invalidConfigError, "%s.start %#q must be a time of day in %#q format", path, w.Start, "15:04")
+	}
+	w.end, err = parseTimeOfDay(w.End)
+	if err != nil {
+		return microerror.Maskf(invalidConfigError, "%s.end %#q must be a time of day in %#q format", path, w.End, "15:04")
+	}
+	if w.start == w.end {
+		return microerror.Maskf(invalidConfigError, "%s.start and %s.end must not be equal", path, path)
+	}
+
+	w.location, err = time.LoadLocation(w.Timezone)
+	if err != nil {
+		return microerror.Maskf(invalidConfigError, "%s.timezone %#q is invalid: %s", path, w.Timezone, err)
+	}
+
+	return nil
+}
+
+// parseTimeOfDay returns the time since midnight of the time of day in
+// "15:04" format.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, microerror.Mask(err)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_QuietWindow_Contains(t *testing.T) {
+	// 2024-07-01 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 7, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name     string
+		window   QuietWindow
+		times    []time.Time
+		expected []bool
+	}{
+		{
+			name: "case 0: window within a day",
+			window: QuietWindow{
+				Days:  []string{"mon"},
+				Start: "09:00",
+				End:   "17:00",
+			},
+			times:    []time.Time{at(1, 8, 59), at(1, 9, 0), at(1, 16, 59), at(1, 17, 0), at(2, 10, 0)},
+			expected: []bool{false, true, true, false, false},
+		},
+		{
+			name: "case 1: window spanning midnight ends on the next day",
+			window: QuietWindow{
+				Days:  []string{"fri"},
+				Start: "22:00",
+				End:   "06:00",
+			},
+			// Friday 21:59, Friday 22:00, Saturday 05:59, Saturday
+			// 06:00, Saturday 23:00 and Friday 05:00 after Thursday.
+			times:    []time.Time{at(5, 21, 59), at(5, 22, 0), at(6, 5, 59), at(6, 6, 0), at(6, 23, 0), at(5, 5, 0)},
+			expected: []bool{false, true, true, false, false, false},
+		},
+		{
+			name: "case 2: window spanning midnight every day",
+			window: QuietWindow{
+				Start: "22:00",
+				End:   "06:00",
+			},
+			times:    []time.Time{at(1, 1, 0), at(1, 12, 0), at(1, 23, 0)},
+			expected: []bool{true, false, true},
+		},
+		{
+			name: "case 3: window in a time zone",
+			window: QuietWindow{
+				Start:    "09:00",
+				End:      "17:00",
+				Timezone: "Europe/Berlin",
+			},
+			// Berlin is 2 hours ahead of UTC in summer.
+			times:    []time.Time{at(1, 6, 59), at(1, 7, 0), at(1, 14, 59), at(1, 15, 0)},
+			expected: []bool{false, true, true, false},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := tc.window
+			err := w.validate("window", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, ti := range tc.times {
+				t.Run(strconv.Itoa(i), func(t *testing.T) {
+					contains := w.Contains(ti)
+					if contains != tc.expected[i] {
+						t.Fatalf("Contains(%s) == %t, want %t", ti.Format(time.RFC3339), contains, tc.expected[i])
+					}
+				})
+			}
+		})
+	}
+}
+
+func Test_Schedule_Quiet(t *testing.T) {
+	s := Schedule{
+		QuietWindows: []QuietWindow{
+			{
+				Destinations: []string{"docker.io"},
+				Start:        "09:00",
+				End:          "17:00",
+			},
+		},
+	}
+
+	err := s.validate("schedule", []Destination{{Registry: Registry{Name: "docker.io"}}, {Registry: Registry{Name: "gsoci.azurecr.io"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	if !s.Quiet("docker.io", now) {
+		t.Fatalf("docker.io is not quiet, want quiet")
+	}
+	if s.Quiet("gsoci.azurecr.io", now) {
+		t.Fatalf("gsoci.azurecr.io is quiet, want not quiet")
+	}
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/giantswarm/microerror"
+)
+
+// TagFilter selects tags of a repository to sync. Tags must pass all the
+// configured conditions.
+type TagFilter struct {
+	// Include lists regular expressions. When not empty only tags matching
+	// any of them are synced.
+	Include []string `yaml:"include"`
+	// Exclude lists regular expressions. Tags matching any of them are not
+	// synced.
+	Exclude []string `yaml:"exclude"`
+	// Semver is a semantic version constraint, e.g. ">=1.0.0". When set only
+	// tags being semantic versions satisfying it are synced.
+	Semver string `yaml:"semver"`
+	// ExcludePrereleases drops tags being semantic versions with
+	// a prerelease part, e.g. "1.0.0-rc1".
+	ExcludePrereleases bool `yaml:"excludePrereleases"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	semver  *semver.Constraints
+}
+
+// TagRule applies the tag filter to repositories matching the pattern.
+type TagRule struct {
+	// Match is a regular expression matched against source repository
+	// names.
+	Match string    `yaml:"match"`
+	Tags  TagFilter `yaml:"tags"`
+
+	match *regexp.Regexp
+}
+
+// TagFilter returns the filter of the first rule matching the repository or
+// the default one. It must be called after Validate.
+func (r Repositories) TagFilter(repository string) TagFilter {
+	for _, rule := range r.Rules {
+		if rule.match.MatchString(repository) {
+			return rule.Tags
+		}
+	}
+
+	return r.Tags
+}
+
+// Filter returns tags passing the filter. It must be called after Validate.
+func (f TagFilter) Filter(tags []string) []string {
+	var result []string
+	for _, t := range tags {
+		if f.Matches(t) {
+			result = append(result, t)
+		}
+	}
+
+	return result
+}
+
+// Matches returns true when the tag passes the filter. It must be called
+// after Validate.
+func (f TagFilter) Matches(tag string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(tag) {
+			return false
+		}
+	}
+
+	if len(f.include) > 0 {
+		var included bool
+		for _, re := range f.include {
+			if re.MatchString(tag) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	if f.semver == nil && !f.ExcludePrereleases {
+		return true
+	}
+
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		// Tags which are not semantic versions can not satisfy the
+		// constraint. Without the constraint there is no prerelease to
+		// exclude.
+		return f.semver == nil
+	}
+	if f.ExcludePrereleases && v.Prerelease() != "" {
+		return false
+	}
+	if f.semver != nil && !f.semver.Check(v) {
+		return false
+	}
+
+	return true
+}
+
+func (f *TagFilter) validate(path string) error {
+	var err error
+
+	f.include, err = compilePatterns(path+".include", f.Include)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	f.exclude, err = compilePatterns(path+".exclude", f.Exclude)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if f.Semver != "" {
+		f.semver, err = semver.NewConstraint(f.Semver)
+		if err != nil {
+			return microerror.Maskf(invalidConfigError, "%s.semver is not a valid constraint: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *TagRule) validate(path string) error {
+	var err error
+
+	if r.Match == "" {
+		return microerror.Maskf(invalidConfigError, "%s.match must not be empty", path)
+	}
+	r.match, err = regexp.Compile(r.Match)
+	if err != nil {
+		return microerror.Maskf(invalidConfigError, "%s.match is not a valid regular expression: %s", path, err)
+	}
+
+	err = r.Tags.validate(fmt.Sprintf("%s.tags", path))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/giantswarm/crsync/pkg/tracing"
+)
+
+type Config struct {
+	// TracerProvider creates spans for copies, manifests and blobs. It is
+	// optional.
+	TracerProvider trace.TracerProvider
+}
+
+// Copier streams images between registries over the distribution API without
+// storing them locally.
+type Copier struct {
+	blobInfoCache types.BlobInfoCache
+	tracer        trace.Tracer
+}
+
+// Stats are amounts of data transferred by a single copy. Blobs which
+// already exist in a destination are not counted.
+type Stats struct {
+	// PulledBytes is the size of manifests and blobs read from the source.
+	PulledBytes int64
+	// PushedBytes is the size of manifests and blobs written to the
+	// destination with the same index.
+	PushedBytes []int64
+}
+
+func New(c Config) (*Copier, error) {
+	return &Copier{
+		blobInfoCache: none.NoCache,
+		tracer:        tracing.Tracer(c.TracerProvider, "github.com/giantswarm/crsync/pkg/copier"),
+	}, nil
+}
+
+// Copy transfers the image opened as src to all dsts. Manifest lists and OCI
+// image indexes are copied together with all their instances. Manifests are
+// written byte-for-byte so the destination digests match the source ones.
+//
+// Every blob is read from src once and streamed to all the destinations
+// missing it. A failing destination does not stop the copy to the other ones.
+// The returned slice holds the error for the destination with the same index
+// or nil when the copy succeeded. Errors caused by the source are matched by
+// IsSourceFailed.
+func (c *Copier) Copy(ctx context.Context, src types.ImageSource, dsts []types.ImageDestination) (Stats, []error) {
+	ctx, span := c.tracer.Start(ctx, "copier.copy", trace.WithAttributes(
+		attribute.String("source", src.Reference().StringWithinTransport()),
+		attribute.Int("destinations", len(dsts)),
+	))
+
+	d := newDestinations(dsts)
+	defer func() {
+		span.SetAttributes(attribute.Int64("pulledBytes", d.stats.PulledBytes))
+		tracing.End(span, errors.Join(d.errs...))
+	}()
+
+	err := c.copyManifest(ctx, src, d, nil)
+	if err != nil {
+		d.FailAll(microerror.Maskf(sourceFailedError, "%s", err))
+	}
+
+	for _, i := range d.Active() {
+		err = d.dsts[i].Commit(ctx, image.UnparsedInstance(src, nil))
+		if err != nil {
+			d.Fail(i, microerror.Maskf(executionFailedError, "failed to commit %#q with error: %s", d.dsts[i].Reference().StringWithinTransport(), err))
+		}
+	}
+
+	return d.stats, d.errs
+}
+
+// copyManifest copies the manifest identified by instanceDigest, or the
+// top-level manifest when instanceDigest is nil, together with everything it
+// references. Instances of a manifest list are pushed by digest before the
+// list itself because registries reject lists referencing unknown manifests.
+//
+// Errors of destinations are recorded in d. The returned error means the
+// source failed.
+func (c *Copier) copyManifest(ctx context.Context, src types.ImageSource, d *destinations, instanceDigest *digest.Digest) (err error) {
+	ctx, span := c.tracer.Start(ctx, "copier.copyManifest")
+	defer func() { tracing.End(span, err) }()
+	if instanceDigest != nil {
+		span.SetAttributes(attribute.String("digest", instanceDigest.String()))
+	}
+
+	manifestBlob, manifestType, err := src.GetManifest(ctx, instanceDigest)
+	if err != nil {
+		return microerror.Maskf(executionFailedError, "failed to get manifest %s of %#q with error: %s", instanceName(instanceDigest), src.Reference().StringWithinTransport(), err)
+	}
+	d.stats.PulledBytes += int64(len(manifestBlob))
+	span.SetAttributes(attribute.String("mediaType", manifestType))
+	if instanceDigest != nil {
+		matches, err := manifest.MatchesDigest(manifestBlob, *instanceDigest)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		if !matches {
+			return microerror.Maskf(executionFailedError, "manifest %s of %#q does not match its digest", instanceName(instanceDigest), src.Reference().StringWithinTransport())
+		}
+	}
+
+	if manifest.MIMETypeIsMultiImage(manifestType) {
+		list, err := manifest.ListFromBlob(manifestBlob, manifestType)
+		if err != nil {
+			return microerror.Maskf(executionFailedError, "failed to parse manifest list %s of %#q with error: %s", instanceName(instanceDigest), src.Reference().StringWithinTransport(), err)
+		}
+
+		for _, instance := range list.Instances() {
+			instance := instance
+			err = c.copyManifest(ctx, src, d, &instance)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+	} else {
+		err = c.copyBlobs(ctx, src, d, manifestBlob, manifestType)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	for _, i := range d.Active() {
+		err = d.dsts[i].PutManifest(ctx, manifestBlob, instanceDigest)
+		if err != nil {
+			d.Fail(i, microerror.Maskf(executionFailedError, "failed to put manifest %s to %#q with error: %s", instanceName(instanceDigest), d.dsts[i].Reference().StringWithinTransport(), err))
+			continue
+		}
+		d.stats.PushedBytes[i] += int64(len(manifestBlob))
+	}
+
+	return nil
+}
+
+func (c *Copier) copyBlobs(ctx context.Context, src types.ImageSource, d *destinations, manifestBlob []byte, manifestType string) error {
+	m, err := manifest.FromBlob(manifestBlob, manifestType)
+	if err != nil {
+		return microerror.Maskf(executionFailedError, "failed to parse manifest of %#q with error: %s", src.Reference().StringWithinTransport(), err)
+	}
+
+	// Schema 1 manifests do not reference a config blob.
+	config := m.ConfigInfo()
+	if config.Digest != "" {
+		err = c.copyBlob(ctx, src, d, config, true)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	for _, layer := range m.LayerInfos() {
+		// Foreign layers are not stored in the registry. They are referenced
+		// by URL in the manifest instead.
+		if len(layer.URLs) > 0 {
+			continue
+		}
+
+		err = c.copyBlob(ctx, src, d, layer.BlobInfo, false)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Copier) copyBlob(ctx context.Context, src types.ImageSource, d *destinations, info types.BlobInfo, isConfig bool) (err error) {
+	ctx, span := c.tracer.Start(ctx, "copier.copyBlob", trace.WithAttributes(
+		attribute.String("digest", info.Digest.String()),
+		attribute.Int64("size", info.Size),
+		attribute.Bool("config", isConfig),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	var missing []int
+	for _, i := range d.Active() {
+		reused, _, err := d.dsts[i].TryReusingBlob(ctx, info, c.blobInfoCache, false)
+		if err != nil {
+			d.Fail(i, microerror.Maskf(executionFailedError, "failed to check blob %#q in %#q with error: %s", info.Digest, d.dsts[i].Reference().StringWithinTransport(), err))
+			continue
+		}
+		if !reused {
+			missing = append(missing, i)
+		}
+	}
+	span.SetAttributes(attribute.Int("missingDestinations", len(missing)))
+	if len(missing) == 0 {
+		return nil
+	}
+
+	blob, size, err := src.GetBlob(ctx, info, c.blobInfoCache)
+	if err != nil {
+		return microerror.Maskf(executionFailedError, "failed to get blob %#q from %#q with error: %s", info.Digest, src.Reference().StringWithinTransport(), err)
+	}
+	defer blob.Close()
+
+	stream := &countingReader{Reader: blob}
+	defer func() {
+		d.stats.PulledBytes += stream.n
+	}()
+
+	if info.Size == -1 {
+		info.Size = size
+	}
+
+	if len(missing) == 1 {
+		i := missing[0]
+
+		_, err = d.dsts[i].PutBlob(ctx, stream, info, c.blobInfoCache, isConfig)
+		if stream.err != nil {
+			return microerror.Maskf(sourceFailedError, "failed to read blob %#q from %#q with error: %s", info.Digest, src.Reference().StringWithinTransport(), stream.err)
+		}
+		if err != nil {
+			d.Fail(i, microerror.Maskf(executionFailedError, "failed to put blob %#q to %#q with error: %s", info.Digest, d.dsts[i].Reference().StringWithinTransport(), err))
+			return nil
+		}
+		d.stats.PushedBytes[i] += stream.n
+
+		return nil
+	}
+
+	// Stream the blob to all destinations at once through pipes.
+	errs := make([]error, len(missing))
+	pipes := make([]*io.PipeWriter, len(missing))
+	wg := sync.WaitGroup{}
+	for j, i := range missing {
+		pr, pw := io.Pipe()
+		pipes[j] = pw
+
+		wg.Add(1)
+		go func(j, i int) {
+			defer wg.Done()
+			_, errs[j] = d.dsts[i].PutBlob(ctx, pr, info, c.blobInfoCache, isConfig)
+			// Unblock the writer in case the destination stopped reading
+			// before the end of the stream.
+			_ = pr.Close()
+		}(j, i)
+	}
+
+	w := newPipesWriter(pipes)
+	_, err = io.Copy(w, stream)
+	w.CloseWithError(err)
+	wg.Wait()
+
+	// Destinations fail too when the source stream breaks. The source is
+	// to blame then.
+	if stream.err != nil {
+		return microerror.Maskf(sourceFailedError, "failed to read blob %#q from %#q with error: %s", info.Digest, src.Reference().StringWithinTransport(), stream.err)
+	}
+
+	for j, i := range missing {
+		if errs[j] != nil {
+			d.Fail(i, microerror.Maskf(executionFailedError, "failed to put blob %#q to %#q with error: %s", info.Digest, d.dsts[i].Reference().StringWithinTransport(), errs[j]))
+			continue
+		}
+		d.stats.PushedBytes[i] += stream.n
+	}
+
+	return nil
+}
+
+func instanceName(instanceDigest *digest.Digest) string {
+	if instanceDigest == nil {
+		return "`top-level`"
+	}
+
+	return fmt.Sprintf("%#q", *instanceDigest)
+}
@@ -0,0 +1,114 @@
+package copier
+
+import (
+	"io"
+
+	"github.com/containers/image/v5/types"
+)
+
+// destinations tracks the state of every destination of a single copy. Once
+// an operation fails for a destination it is excluded from the rest of the
+// copy so the other destinations are not affected.
+type destinations struct {
+	dsts  []types.ImageDestination
+	errs  []error
+	stats Stats
+}
+
+func newDestinations(dsts []types.ImageDestination) *destinations {
+	return &destinations{
+		dsts: dsts,
+		errs: make([]error, len(dsts)),
+		stats: Stats{
+			PushedBytes: make([]int64, len(dsts)),
+		},
+	}
+}
+
+// Active returns indexes of destinations which did not fail yet.
+func (d *destinations) Active() []int {
+	var active []int
+	for i, err := range d.errs {
+		if err == nil {
+			active = append(active, i)
+		}
+	}
+
+	return active
+}
+
+func (d *destinations) Fail(i int, err error) {
+	if d.errs[i] == nil {
+		d.errs[i] = err
+	}
+}
+
+// FailAll fails all the active destinations with err.
+func (d *destinations) FailAll(err error) {
+	for _, i := range d.Active() {
+		d.Fail(i, err)
+	}
+}
+
+// pipesWriter writes to all the pipes skipping the ones which readers stopped
+// reading. It fails only when there is no pipe left to write to.
+type pipesWriter struct {
+	pipes  []*io.PipeWriter
+	closed []bool
+}
+
+func newPipesWriter(pipes []*io.PipeWriter) *pipesWriter {
+	return &pipesWriter{
+		pipes:  pipes,
+		closed: make([]bool, len(pipes)),
+	}
+}
+
+func (w *pipesWriter) Write(p []byte) (int, error) {
+	var written bool
+	for i, pipe := range w.pipes {
+		if w.closed[i] {
+			continue
+		}
+
+		_, err := pipe.Write(p)
+		if err != nil {
+			w.closed[i] = true
+			continue
+		}
+
+		written = true
+	}
+
+	if !written {
+		return 0, io.ErrClosedPipe
+	}
+
+	return len(p), nil
+}
+
+// CloseWithError closes all pipes. Readers receive err or io.EOF when err is
+// nil.
+func (w *pipesWriter) CloseWithError(err error) {
+	for _, pipe := range w.pipes {
+		_ = pipe.CloseWithError(err)
+	}
+}
+
+// countingReader counts bytes read from the underlying reader and keeps the
+// first error other than io.EOF so failures of the source can be told apart
+// from failures of destinations consuming the stream.
+type countingReader struct {
+	io.Reader
+	n   int64
+	err error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
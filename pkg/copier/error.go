@@ -0,0 +1,22 @@
+package copier
+
+import "github.com/giantswarm/microerror"
+
+// executionFailedError should never be matched against and therefore there is
+// no matcher implement. For further information see:
+//
+//	https://github.com/giantswarm/fmt/blob/master/go/errors.md#matching-errors
+var executionFailedError = &microerror.Error{
+	Kind: "executionFailedError",
+}
+
+// sourceFailedError is returned for destinations which were not copied to
+// because reading the source failed.
+var sourceFailedError = &microerror.Error{
+	Kind: "sourceFailedError",
+}
+
+// IsSourceFailed asserts sourceFailedError.
+func IsSourceFailed(err error) bool {
+	return microerror.Cause(err) == sourceFailedError
+}
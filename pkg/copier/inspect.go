@@ -0,0 +1,127 @@
+package copier
+
+import (
+	"context"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+)
+
+// Size returns the number of bytes Copy would transfer to a destination
+// having none of the image blobs. It is the sum of sizes of all manifests,
+// config blobs and layers including all instances of manifest lists. Blobs
+// shared by multiple instances are counted once.
+func (c *Copier) Size(ctx context.Context, src types.ImageSource) (int64, error) {
+	var size int64
+
+	err := c.walk(ctx, src, nil, map[digest.Digest]bool{}, func(manifestBlob []byte, blob *types.BlobInfo) error {
+		if blob == nil {
+			size += int64(len(manifestBlob))
+			return nil
+		}
+
+		// Schema 1 manifests do not record sizes of layers.
+		if blob.Size > 0 {
+			size += blob.Size
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, microerror.Mask(err)
+	}
+
+	return size, nil
+}
+
+// MissingBlobs returns digests of blobs referenced by the image opened as src
+// which do not exist in dst. Manifest lists are checked with all their
+// instances.
+func (c *Copier) MissingBlobs(ctx context.Context, src types.ImageSource, dst types.ImageDestination) ([]digest.Digest, error) {
+	var missing []digest.Digest
+
+	err := c.walk(ctx, src, nil, map[digest.Digest]bool{}, func(_ []byte, blob *types.BlobInfo) error {
+		if blob == nil {
+			return nil
+		}
+
+		exists, _, err := dst.TryReusingBlob(ctx, *blob, c.blobInfoCache, false)
+		if err != nil {
+			return microerror.Maskf(executionFailedError, "failed to check blob %#q in %#q with error: %s", blob.Digest, dst.Reference().StringWithinTransport(), err)
+		}
+		if !exists {
+			missing = append(missing, blob.Digest)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return missing, nil
+}
+
+// walk calls fn for every manifest with nil blob and for every blob copied by
+// Copy, visiting instances of manifest lists recursively. Blobs seen before
+// are skipped.
+func (c *Copier) walk(ctx context.Context, src types.ImageSource, instanceDigest *digest.Digest, seen map[digest.Digest]bool, fn func(manifestBlob []byte, blob *types.BlobInfo) error) error {
+	manifestBlob, manifestType, err := src.GetManifest(ctx, instanceDigest)
+	if err != nil {
+		return microerror.Maskf(executionFailedError, "failed to get manifest %s of %#q with error: %s", instanceName(instanceDigest), src.Reference().StringWithinTransport(), err)
+	}
+
+	err = fn(manifestBlob, nil)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	if manifest.MIMETypeIsMultiImage(manifestType) {
+		list, err := manifest.ListFromBlob(manifestBlob, manifestType)
+		if err != nil {
+			return microerror.Maskf(executionFailedError, "failed to parse manifest list %s of %#q with error: %s", instanceName(instanceDigest), src.Reference().StringWithinTransport(), err)
+		}
+
+		for _, instance := range list.Instances() {
+			instance := instance
+			err = c.walk(ctx, src, &instance, seen, fn)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+
+		return nil
+	}
+
+	m, err := manifest.FromBlob(manifestBlob, manifestType)
+	if err != nil {
+		return microerror.Maskf(executionFailedError, "failed to parse manifest of %#q with error: %s", src.Reference().StringWithinTransport(), err)
+	}
+
+	blobs := []types.BlobInfo{m.ConfigInfo()}
+	for _, layer := range m.LayerInfos() {
+		// Foreign layers are not copied, see copyBlobs.
+		if len(layer.URLs) > 0 {
+			continue
+		}
+		blobs = append(blobs, layer.BlobInfo)
+	}
+
+	for _, b := range blobs {
+		b := b
+
+		if b.Digest == "" || seen[b.Digest] {
+			continue
+		}
+		seen[b.Digest] = true
+
+		err = fn(manifestBlob, &b)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package distribution
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const (
+	// defaultTokenTTL is used when the token server does not return
+	// expires_in. The distribution spec defines 60 seconds as the default.
+	defaultTokenTTL = 60 * time.Second
+	// tokenExpiryMargin makes tokens to be refreshed slightly before they
+	// expire to not use them in flight.
+	tokenExpiryMargin = 5 * time.Second
+)
+
+type challenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+type token struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+// authorization returns the value of Authorization header for the given scope
+// or an empty string if the scope was not authenticated yet.
+func (d *Distribution) authorization(scope string) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.basicAuth {
+		return basicAuthorization(d.user, d.password)
+	}
+
+	t, ok := d.tokens[scope]
+	if !ok || time.Now().After(t.ExpiresAt) {
+		return ""
+	}
+
+	return fmt.Sprintf("Bearer %s", t.Value)
+}
+
+func (d *Distribution) credentials() (string, string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.user, d.password
+}
+
+// authenticate answers the WWW-Authenticate challenge returned by the
+// registry for the given scope.
+func (d *Distribution) authenticate(ctx context.Context, header string, scope string) error {
+	c, err := parseChallenge(header)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	switch strings.ToLower(c.Scheme) {
+	case "basic":
+		if user, _ := d.credentials(); user == "" {
+			return microerror.Maskf(executionFailedError, "registry %#q requires basic authentication but no credentials were given", d.registryName)
+		}
+
+		d.mutex.Lock()
+		d.basicAuth = true
+		d.mutex.Unlock()
+
+		return nil
+
+	case "bearer":
+		t, err := d.requestToken(ctx, c, scope)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+
+		d.mutex.Lock()
+		d.tokens[scope] = t
+		d.mutex.Unlock()
+
+		return nil
+
+	default:
+		return microerror.Maskf(executionFailedError, "unsupported authentication scheme %#q", c.Scheme)
+	}
+}
+
+func (d *Distribution) requestToken(ctx context.Context, c challenge, scope string) (token, error) {
+	realm := c.Params["realm"]
+	if realm == "" {
+		return token{}, microerror.Maskf(executionFailedError, "bearer challenge of registry %#q has no realm", d.registryName)
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return token{}, microerror.Mask(err)
+	}
+
+	query := u.Query()
+	if service := c.Params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	// Prefer the scope requested by the registry. Not every registry sends
+	// it in the challenge though.
+	if s := c.Params["scope"]; s != "" {
+		query.Set("scope", s)
+	} else if scope != "" {
+		query.Set("scope", scope)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return token{}, microerror.Mask(err)
+	}
+
+	if user, password := d.credentials(); user != "" {
+		req.SetBasicAuth(user, password)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return token{}, microerror.Mask(err)
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return token{}, microerror.Mask(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return token{}, microerror.Maskf(executionFailedError, "token request to %#q failed with status %d", realm, resp.StatusCode)
+	}
+
+	var tokenJSON TokenJSON
+	err = json.Unmarshal(body, &tokenJSON)
+	if err != nil {
+		return token{}, microerror.Mask(err)
+	}
+
+	value := tokenJSON.Token
+	if value == "" {
+		value = tokenJSON.AccessToken
+	}
+	if value == "" {
+		return token{}, microerror.Maskf(executionFailedError, "token request to %#q returned empty token", realm)
+	}
+
+	ttl := defaultTokenTTL
+	if tokenJSON.ExpiresIn > 0 {
+		ttl = time.Duration(tokenJSON.ExpiresIn) * time.Second
+	}
+	issuedAt := tokenJSON.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = time.Now()
+	}
+
+	t := token{
+		Value:     value,
+		ExpiresAt: issuedAt.Add(ttl - tokenExpiryMargin),
+	}
+
+	return t, nil
+}
+
+// parseChallenge parses WWW-Authenticate header value like:
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:foo/bar:pull"
+func parseChallenge(header string) (challenge, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return challenge{}, microerror.Maskf(executionFailedError, "missing WWW-Authenticate header")
+	}
+
+	scheme, rest, _ := strings.Cut(header, " ")
+
+	c := challenge{
+		Scheme: scheme,
+		Params: map[string]string{},
+	}
+
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		if rest == "" {
+			break
+		}
+
+		var key string
+		var ok bool
+		key, rest, ok = strings.Cut(rest, "=")
+		if !ok {
+			return challenge{}, microerror.Maskf(executionFailedError, "malformed WWW-Authenticate header %#q", header)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				return challenge{}, microerror.Maskf(executionFailedError, "malformed WWW-Authenticate header %#q", header)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+
+		c.Params[key] = value
+	}
+
+	return c, nil
+}
+
+func basicAuthorization(user, password string) string {
+	b64creds := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", user, password)))
+
+	return fmt.Sprintf("Basic %s", b64creds)
+}
@@ -0,0 +1,342 @@
+package distribution
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/giantswarm/crsync/pkg/registry"
+)
+
+const (
+	// pageSize is the number of entries requested from paginated
+	// endpoints. Registries are free to return less.
+	pageSize = 100
+
+	catalogScope = "registry:catalog:*"
+)
+
+// manifestMediaTypes are accepted when requesting manifests so registries
+// return the digest of the manifest as pushed instead of converting it.
+var manifestMediaTypes = []string{
+	imgspecv1.MediaTypeImageIndex,
+	imgspecv1.MediaTypeImageManifest,
+	manifest.DockerV2ListMediaType,
+	manifest.DockerV2Schema2MediaType,
+}
+
+type Config struct {
+	// RegistryName is the registry host with optional port. E.g.:
+	// "harbor.example.com" or "localhost:5000".
+	RegistryName string
+	// Namespaces optionally limit listed repositories to the ones with one
+	// of the given path prefixes.
+	Namespaces []string
+	// Insecure makes the client fall back to plain HTTP when the registry
+	// can not be reached over HTTPS. TLS verification is configured in
+	// HTTPClient.
+	Insecure bool
+	// HTTPClient is optional. http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+}
+
+// Distribution is a registry.RegistryClient for any registry implementing
+// the OCI distribution spec, e.g. distribution/registry, Harbor, Zot or
+// Gitea.
+type Distribution struct {
+	registryName string
+	insecure     bool
+	namespaces   []string
+
+	mutex sync.Mutex
+	// registryEndpoint is changed to plain HTTP when the registry is
+	// insecure and can not be reached over HTTPS.
+	registryEndpoint string
+	user             string
+	password         string
+	basicAuth        bool
+	tokens           map[string]token
+
+	httpClient *http.Client
+}
+
+func New(c Config) (*Distribution, error) {
+	if c.RegistryName == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.RegistryName must not be empty", c)
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+
+	return &Distribution{
+		registryName:     c.RegistryName,
+		registryEndpoint: fmt.Sprintf("https://%s", c.RegistryName),
+		insecure:         c.Insecure,
+		namespaces:       c.Namespaces,
+
+		tokens: map[string]token{},
+
+		httpClient: c.HTTPClient,
+	}, nil
+}
+
+func (d *Distribution) Authorize(ctx context.Context, user, password string) error {
+	d.mutex.Lock()
+	d.user = user
+	d.password = password
+	d.basicAuth = false
+	d.tokens = map[string]token{}
+	d.mutex.Unlock()
+
+	// Check the credentials against the API version check endpoint.
+	resp, err := d.get(ctx, fmt.Sprintf("%s/v2/", d.endpoint()), "")
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return microerror.Maskf(executionFailedError, "failed to authorize in registry %#q with status %d", d.registryName, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *Distribution) ListRepositories(ctx context.Context) ([]string, error) {
+	endpoint := fmt.Sprintf("%s/v2/_catalog?n=%d", d.endpoint(), pageSize)
+
+	var repos []string
+	for endpoint != "" {
+		var catalog CatalogJSON
+
+		next, err := d.getJSON(ctx, endpoint, catalogScope, &catalog)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		for _, repo := range catalog.Repositories {
+			if registry.InNamespaces(repo, d.namespaces) {
+				repos = append(repos, repo)
+			}
+		}
+		endpoint = next
+	}
+
+	return repos, nil
+}
+
+func (d *Distribution) ListTags(ctx context.Context, repository string) ([]string, error) {
+	endpoint := fmt.Sprintf("%s/v2/%s/tags/list?n=%d", d.endpoint(), repository, pageSize)
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+
+	tags := []string{}
+	for endpoint != "" {
+		var tagsJSON TagsJSON
+
+		next, err := d.getJSON(ctx, endpoint, scope, &tagsJSON)
+		if IsNotFound(err) {
+			// The repository does not exist (yet).
+			return []string{}, nil
+		} else if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		tags = append(tags, tagsJSON.Tags...)
+		endpoint = next
+	}
+
+	return tags, nil
+}
+
+// DeleteTag resolves the digest of the manifest the tag references and
+// deletes the manifest by digest, which every registry implementing the
+// distribution spec supports, unlike deleting by tag. Other tags referencing
+// the same manifest are deleted with it, see registry.Interface.
+func (d *Distribution) DeleteTag(ctx context.Context, repository, tag string) error {
+	dgst, err := d.ManifestDigest(ctx, repository, tag)
+	if IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v2/%s/manifests/%s", d.endpoint(), repository, dgst)
+	scope := fmt.Sprintf("repository:%s:delete", repository)
+
+	resp, err := d.request(ctx, http.MethodDelete, endpoint, scope, nil)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return microerror.Maskf(executionFailedError, "deleting tag %#q failed with status %d", fmt.Sprintf("%s:%s", repository, tag), resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ManifestDigest returns the digest of the manifest the tag references. It
+// sends a HEAD request, which Docker Hub does not count against the pull quota
+// but reports the quota in response headers.
+func (d *Distribution) ManifestDigest(ctx context.Context, repository, tag string) (digest.Digest, error) {
+	endpoint := fmt.Sprintf("%s/v2/%s/manifests/%s", d.endpoint(), repository, tag)
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+
+	header := http.Header{}
+	for _, t := range manifestMediaTypes {
+		header.Add("Accept", t)
+	}
+
+	resp, err := d.request(ctx, http.MethodHead, endpoint, scope, header)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", microerror.Maskf(notFoundError, "%#q", endpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", microerror.Maskf(executionFailedError, "requesting manifest %#q failed with status %d", fmt.Sprintf("%s:%s", repository, tag), resp.StatusCode)
+	}
+
+	dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return "", microerror.Maskf(executionFailedError, "manifest %#q has invalid digest: %s", fmt.Sprintf("%s:%s", repository, tag), err)
+	}
+
+	return dgst, nil
+}
+
+// getJSON fetches endpoint and decodes the response body into v. It returns
+// the next page URL taken from the Link header or an empty string when there
+// are no more pages.
+func (d *Distribution) getJSON(ctx context.Context, endpoint, scope string, v interface{}) (string, error) {
+	resp, err := d.get(ctx, endpoint, scope)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", microerror.Maskf(notFoundError, "%#q", endpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", microerror.Maskf(executionFailedError, "request to %#q failed with status %d", endpoint, resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	link := registry.GetLink(resp.Header.Get("Link"))
+	if link == "" {
+		return "", nil
+	}
+
+	// The Link header is usually relative to the registry endpoint.
+	next, err := resp.Request.URL.Parse(link)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return next.String(), nil
+}
+
+// get performs GET request authenticating for the given scope when
+// challenged by the registry.
+func (d *Distribution) get(ctx context.Context, endpoint, scope string) (*http.Response, error) {
+	resp, err := d.request(ctx, http.MethodGet, endpoint, scope, nil)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return resp, nil
+}
+
+// request performs the request with the optional header authenticating for
+// the given scope when challenged by the registry.
+func (d *Distribution) request(ctx context.Context, method, endpoint, scope string, header http.Header) (*http.Response, error) {
+	resp, err := d.do(ctx, method, endpoint, scope, header)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	err = d.authenticate(ctx, challenge, scope)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	resp, err = d.do(ctx, method, endpoint, scope, header)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, microerror.Maskf(executionFailedError, "unauthorized to access %#q", endpoint)
+	}
+
+	return resp, nil
+}
+
+func (d *Distribution) do(ctx context.Context, method, endpoint, scope string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if authorization := d.authorization(scope); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil && d.insecure && req.URL.Scheme == "https" {
+		// Insecure registries may only talk plain HTTP. The body is
+		// always empty so the request can be sent again.
+		req.URL.Scheme = "http"
+		resp, err = d.httpClient.Do(req)
+		if err == nil {
+			d.mutex.Lock()
+			d.registryEndpoint = fmt.Sprintf("http://%s", d.registryName)
+			d.mutex.Unlock()
+		}
+	}
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return resp, nil
+}
+
+func (d *Distribution) endpoint() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.registryEndpoint
+}
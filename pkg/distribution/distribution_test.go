@@ -0,0 +1,553 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testUser     = "user"
+	testPassword = "password"
+	testToken    = "token"
+	// testPageSize is the maximum number of entries served in a page so
+	// listing always paginates.
+	testPageSize = 2
+)
+
+// testRegistry is a minimal registry implementing the distribution spec with
+// bearer, basic or no authentication.
+type testRegistry struct {
+	auth string
+	// repositories maps repositories to tags and their digests.
+	repositories map[string]map[string]string
+
+	mutex    sync.Mutex
+	requests []string
+	// tokenRequests are the scopes tokens were requested for.
+	tokenRequests []string
+
+	server *httptest.Server
+}
+
+func newTestRegistry(t *testing.T, auth string, tls bool) *testRegistry {
+	r := &testRegistry{
+		auth: auth,
+		repositories: map[string]map[string]string{
+			"giantswarm/a": {"v1": digestOf("1"), "v2": digestOf("2"), "latest": digestOf("2")},
+			"giantswarm/b": {"v1": digestOf("3")},
+			"other/c":      {"v1": digestOf("4")},
+		},
+	}
+
+	if tls {
+		r.server = httptest.NewTLSServer(http.HandlerFunc(r.serveHTTP))
+	} else {
+		r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	}
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+func (r *testRegistry) Name() string {
+	name := strings.TrimPrefix(r.server.URL, "https://")
+	return strings.TrimPrefix(name, "http://")
+}
+
+func (r *testRegistry) Requests() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string(nil), r.requests...)
+}
+
+func (r *testRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+
+	if !r.authorized(req) {
+		switch r.auth {
+		case "basic":
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		case "bearer":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.server.URL))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mutex.Lock()
+	r.requests = append(r.requests, fmt.Sprintf("%s %s", req.Method, req.URL.RequestURI()))
+	r.mutex.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+
+	case path == "_catalog":
+		var repos []string
+		for repo := range r.repositories {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+		r.servePage(w, req, "repositories", repos)
+
+	case strings.HasSuffix(path, "/tags/list"):
+		repo := strings.TrimSuffix(path, "/tags/list")
+		tags, ok := r.repositories[repo]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var names []string
+		for tag := range tags {
+			names = append(names, tag)
+		}
+		sort.Strings(names)
+		r.servePage(w, req, "tags", names)
+
+	case strings.Contains(path, "/manifests/"):
+		repo, reference, _ := strings.Cut(path, "/manifests/")
+		r.serveManifest(w, req, repo, reference)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *testRegistry) authorized(req *http.Request) bool {
+	switch r.auth {
+	case "basic":
+		user, password, ok := req.BasicAuth()
+		return ok && user == testUser && password == testPassword
+	case "bearer":
+		return req.Header.Get("Authorization") == fmt.Sprintf("Bearer %s", testToken)
+	default:
+		return true
+	}
+}
+
+func (r *testRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	user, password, ok := req.BasicAuth()
+	if !ok || user != testUser || password != testPassword || req.URL.Query().Get("service") != "test" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mutex.Lock()
+	r.tokenRequests = append(r.tokenRequests, req.URL.Query().Get("scope"))
+	r.mutex.Unlock()
+
+	fmt.Fprintf(w, `{"token":%q,"expires_in":300}`, testToken)
+}
+
+// servePage serves values after the "last" query parameter limited to "n"
+// and at most testPageSize, and links the next page with a relative Link
+// header.
+func (r *testRegistry) servePage(w http.ResponseWriter, req *http.Request, key string, values []string) {
+	n, err := strconv.Atoi(req.URL.Query().Get("n"))
+	if err != nil || n <= 0 || n > testPageSize {
+		n = testPageSize
+	}
+	last := req.URL.Query().Get("last")
+
+	start := 0
+	if last != "" {
+		start = sort.SearchStrings(values, last) + 1
+	}
+	end := start + n
+	if end > len(values) {
+		end = len(values)
+	}
+	page := values[start:end]
+
+	if end < len(values) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s?n=%d&last=%s>; rel="next"`, req.URL.Path, n, page[len(page)-1]))
+	}
+
+	var quoted []string
+	for _, v := range page {
+		quoted = append(quoted, strconv.Quote(v))
+	}
+	fmt.Fprintf(w, `{%q:[%s]}`, key, strings.Join(quoted, ","))
+}
+
+func (r *testRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, reference string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tags, ok := r.repositories[repo]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodHead:
+		digest, ok := tags[reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		// Like distribution/registry manifests can only be deleted
+		// by digest.
+		if !strings.HasPrefix(reference, "sha256:") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var found bool
+		for tag, digest := range tags {
+			if digest == reference {
+				delete(tags, tag)
+				found = true
+			}
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestDistribution(t *testing.T, r *testRegistry, namespaces []string, insecure bool) *Distribution {
+	d, err := New(Config{
+		RegistryName: r.Name(),
+		Namespaces:   namespaces,
+		Insecure:     insecure,
+		HTTPClient:   r.server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func digestOf(s string) string {
+	return fmt.Sprintf("sha256:%064s", s)
+}
+
+func Test_Distribution_Authorize(t *testing.T) {
+	testCases := []struct {
+		name          string
+		auth          string
+		user          string
+		password      string
+		errorMatcher  func(error) bool
+		tokenRequests []string
+	}{
+		{
+			name:          "case 0: bearer token is requested for challenged scope",
+			auth:          "bearer",
+			user:          testUser,
+			password:      testPassword,
+			tokenRequests: []string{"", "repository:giantswarm/a:pull"},
+		},
+		{
+			name:     "case 1: basic authentication is used when challenged",
+			auth:     "basic",
+			user:     testUser,
+			password: testPassword,
+		},
+		{
+			name: "case 2: no authentication",
+			auth: "",
+		},
+		{
+			name:         "case 3: basic authentication without credentials fails",
+			auth:         "basic",
+			errorMatcher: IsExecutionFailed,
+		},
+		{
+			name:         "case 4: bearer token with invalid credentials fails",
+			auth:         "bearer",
+			user:         testUser,
+			password:     "invalid",
+			errorMatcher: IsExecutionFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			r := newTestRegistry(t, tc.auth, true)
+			d := newTestDistribution(t, r, nil, false)
+
+			err := d.Authorize(ctx, tc.user, tc.password)
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+			if tc.errorMatcher != nil {
+				return
+			}
+
+			tags, err := d.ListTags(ctx, "giantswarm/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"latest", "v1", "v2"}; !reflect.DeepEqual(tags, want) {
+				t.Fatalf("tags == %v, want %v", tags, want)
+			}
+
+			if !reflect.DeepEqual(r.tokenRequests, tc.tokenRequests) {
+				t.Fatalf("token requests == %q, want %q", r.tokenRequests, tc.tokenRequests)
+			}
+		})
+	}
+}
+
+func Test_Distribution_ListRepositories(t *testing.T) {
+	testCases := []struct {
+		name         string
+		namespaces   []string
+		repositories []string
+	}{
+		{
+			name:         "case 0: all repositories are listed across pages",
+			repositories: []string{"giantswarm/a", "giantswarm/b", "other/c"},
+		},
+		{
+			name:         "case 1: repositories are limited to namespaces",
+			namespaces:   []string{"giantswarm"},
+			repositories: []string{"giantswarm/a", "giantswarm/b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			r := newTestRegistry(t, "bearer", true)
+			d := newTestDistribution(t, r, tc.namespaces, false)
+
+			err := d.Authorize(ctx, testUser, testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			repositories, err := d.ListRepositories(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(repositories, tc.repositories) {
+				t.Fatalf("repositories == %v, want %v", repositories, tc.repositories)
+			}
+		})
+	}
+}
+
+func Test_Distribution_ListTags(t *testing.T) {
+	testCases := []struct {
+		name       string
+		repository string
+		pageSize   int
+		tags       []string
+	}{
+		{
+			name:       "case 0: tags are listed across pages",
+			repository: "giantswarm/a",
+			tags:       []string{"latest", "v1", "v2"},
+		},
+		{
+			name:       "case 1: unknown repository has no tags",
+			repository: "giantswarm/unknown",
+			tags:       []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			r := newTestRegistry(t, "bearer", true)
+			d := newTestDistribution(t, r, nil, false)
+
+			err := d.Authorize(ctx, testUser, testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags, err := d.ListTags(ctx, tc.repository)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tags, tc.tags) {
+				t.Fatalf("tags == %v, want %v", tags, tc.tags)
+			}
+		})
+	}
+}
+
+func Test_Distribution_Pagination(t *testing.T) {
+	ctx := context.Background()
+
+	r := newTestRegistry(t, "", true)
+	d := newTestDistribution(t, r, nil, false)
+
+	var page CatalogJSON
+	next, err := d.getJSON(ctx, fmt.Sprintf("%s/v2/_catalog?n=2", d.endpoint()), catalogScope, &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"giantswarm/a", "giantswarm/b"}; !reflect.DeepEqual(page.Repositories, want) {
+		t.Fatalf("repositories == %v, want %v", page.Repositories, want)
+	}
+	// The relative Link header is resolved against the registry.
+	if want := fmt.Sprintf("%s/v2/_catalog?n=2&last=giantswarm/b", r.server.URL); next != want {
+		t.Fatalf("next == %q, want %q", next, want)
+	}
+
+	page = CatalogJSON{}
+	next, err = d.getJSON(ctx, next, catalogScope, &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"other/c"}; !reflect.DeepEqual(page.Repositories, want) {
+		t.Fatalf("repositories == %v, want %v", page.Repositories, want)
+	}
+	if next != "" {
+		t.Fatalf("next == %q, want empty", next)
+	}
+}
+
+func Test_Distribution_DeleteTag(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tag      string
+		requests []string
+		tags     []string
+	}{
+		{
+			name: "case 0: manifest is deleted by resolved digest",
+			tag:  "v1",
+			requests: []string{
+				"HEAD /v2/giantswarm/a/manifests/v1",
+				fmt.Sprintf("DELETE /v2/giantswarm/a/manifests/%s", digestOf("1")),
+			},
+			tags: []string{"latest", "v2"},
+		},
+		{
+			name: "case 1: tags referencing the same manifest are deleted with it",
+			tag:  "v2",
+			requests: []string{
+				"HEAD /v2/giantswarm/a/manifests/v2",
+				fmt.Sprintf("DELETE /v2/giantswarm/a/manifests/%s", digestOf("2")),
+			},
+			tags: []string{"v1"},
+		},
+		{
+			name: "case 2: unknown tag is not deleted",
+			tag:  "v3",
+			requests: []string{
+				"HEAD /v2/giantswarm/a/manifests/v3",
+			},
+			tags: []string{"latest", "v1", "v2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			r := newTestRegistry(t, "bearer", true)
+			d := newTestDistribution(t, r, nil, false)
+
+			err := d.Authorize(ctx, testUser, testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = d.DeleteTag(ctx, "giantswarm/a", tc.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The first request checks authorization.
+			requests := r.Requests()[1:]
+			if !reflect.DeepEqual(requests, tc.requests) {
+				t.Fatalf("requests == %q, want %q", requests, tc.requests)
+			}
+
+			tags, err := d.ListTags(ctx, "giantswarm/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tags, tc.tags) {
+				t.Fatalf("tags == %v, want %v", tags, tc.tags)
+			}
+		})
+	}
+}
+
+func Test_Distribution_Insecure(t *testing.T) {
+	testCases := []struct {
+		name         string
+		insecure     bool
+		errorMatcher func(error) bool
+	}{
+		{
+			name:     "case 0: insecure registry falls back to plain HTTP",
+			insecure: true,
+		},
+		{
+			name:         "case 1: secure registry requires HTTPS",
+			insecure:     false,
+			errorMatcher: func(err error) bool { return err != nil },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			r := newTestRegistry(t, "", false)
+			d := newTestDistribution(t, r, nil, tc.insecure)
+
+			err := d.Authorize(ctx, "", "")
+			switch {
+			case err == nil && tc.errorMatcher == nil:
+				// correct; carry on
+			case err != nil && tc.errorMatcher == nil:
+				t.Fatalf("error == %#v, want nil", err)
+			case err == nil && tc.errorMatcher != nil:
+				t.Fatalf("error == nil, want non-nil")
+			case !tc.errorMatcher(err):
+				t.Fatalf("error == %#v, want matching", err)
+			}
+			if tc.errorMatcher != nil {
+				return
+			}
+
+			if want := r.server.URL; d.endpoint() != want {
+				t.Fatalf("endpoint == %q, want %q", d.endpoint(), want)
+			}
+		})
+	}
+}
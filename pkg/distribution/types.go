@@ -0,0 +1,19 @@
+package distribution
+
+import "time"
+
+type CatalogJSON struct {
+	Repositories []string `json:"repositories"`
+}
+
+type TagsJSON struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+type TokenJSON struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
@@ -8,33 +8,78 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/types"
 	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/crsync/pkg/registry"
 )
 
 const (
+	registryName    = "docker.io"
 	authEndpoint    = "https://hub.docker.com"
 	registryAddress = "https://index.docker.io" // nolint
+
+	// pageSize is the maximum page size accepted by Docker Hub API.
+	pageSize = 100
 )
 
 type Config struct {
+	Logger micrologger.Logger
+
+	// Namespaces are Docker Hub users or organizations which repositories
+	// are listed. They are only required when listing repositories.
+	Namespaces []string
+	// LastModified limits listed repositories to the ones modified within
+	// the duration unless the context was returned by
+	// registry.WithAllRepositories. Zero lists all repositories.
+	LastModified               time.Duration
+	IncludePrivateRepositories bool
+	// HTTPClient is optional. A new client is used when nil.
+	HTTPClient *http.Client
 }
 
 type DockerHub struct {
+	logger micrologger.Logger
+
+	namespaces                 []string
+	lastModified               time.Duration
+	includePrivateRepositories bool
+
 	user     string
 	password string
 	token    string
 
+	mutex sync.Mutex
+	// modified holds when repositories listed by the last
+	// ListRepositories call were last updated.
+	modified map[string]time.Time
+
 	httpClient *http.Client
 }
 
 func New(c Config) (*DockerHub, error) {
-	httpClient := &http.Client{}
+	if c.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
 
 	return &DockerHub{
+		logger: c.Logger,
+
+		namespaces:                 c.Namespaces,
+		lastModified:               c.LastModified,
+		includePrivateRepositories: c.IncludePrivateRepositories,
+
 		httpClient: httpClient,
 	}, nil
 }
@@ -47,7 +92,13 @@ func (d *DockerHub) Authorize(ctx context.Context, user, password string) error
 
 	jsonValues, _ := json.Marshal(values)
 
-	resp, err := d.httpClient.Post(endpoint, "application/json", bytes.NewBuffer(jsonValues)) // nolint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(jsonValues))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.httpClient.Do(req)
 	if err != nil {
 		return microerror.Mask(err)
 	}
@@ -59,13 +110,20 @@ func (d *DockerHub) Authorize(ctx context.Context, user, password string) error
 	}
 
 	type authDataResponse struct {
-		Token string `json:"token"`
+		Token  string `json:"token"`
+		Detail string `json:"detail"`
 	}
 
 	var authData authDataResponse
-	err = json.Unmarshal(body, &authData)
-	if err != nil {
-		return microerror.Mask(err)
+	// Failed logins respond with the reason in the detail field. Bodies
+	// which are not JSON are only reported with the status.
+	_ = json.Unmarshal(body, &authData)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return microerror.Maskf(loginFailedError, "logging in %#q as %#q failed with status %d: %s", registryName, user, resp.StatusCode, authData.Detail)
+	}
+	if authData.Token == "" {
+		return microerror.Maskf(loginFailedError, "logging in %#q as %#q did not return a token", registryName, user)
 	}
 
 	d.user = user
@@ -76,7 +134,63 @@ func (d *DockerHub) Authorize(ctx context.Context, user, password string) error
 }
 
 func (d *DockerHub) ListRepositories(ctx context.Context) ([]string, error) {
-	return nil, microerror.Maskf(executionFailedError, "method not implemented")
+	if len(d.namespaces) == 0 {
+		return nil, microerror.Maskf(executionFailedError, "can not run ListRepositories without namespaces")
+	}
+	if d.token == "" {
+		return nil, microerror.Maskf(executionFailedError, "can not run ListRepositories without calling Authorize first")
+	}
+
+	var repoCount int
+	var reposToSync []string
+	// Full syncs ask for all the repositories.
+	all := registry.AllRepositories(ctx)
+	modified := map[string]time.Time{}
+
+	for _, namespace := range d.namespaces {
+		nextPage := fmt.Sprintf("%s/v2/repositories/%s/?page_size=%d", authEndpoint, namespace, pageSize)
+		for nextPage != "" {
+			repos, err := d.listRepositoriesForPage(ctx, nextPage)
+			if err != nil {
+				return reposToSync, microerror.Mask(err)
+			}
+
+			lastModified := time.Now().Add(-1 * d.lastModified)
+			for _, repo := range repos.Results {
+				if repo.IsPrivate && !d.includePrivateRepositories {
+					continue
+				}
+
+				name := fmt.Sprintf("%s/%s", namespace, repo.Name)
+				if !repo.LastUpdated.IsZero() {
+					modified[name] = repo.LastUpdated
+				}
+
+				if d.lastModified == 0 || all || repo.LastUpdated.After(lastModified) {
+					reposToSync = append(reposToSync, name)
+				}
+			}
+			repoCount += len(repos.Results)
+
+			nextPage = repos.Next
+		}
+	}
+
+	d.mutex.Lock()
+	d.modified = modified
+	d.mutex.Unlock()
+
+	d.logger.LogCtx(ctx, "level", "debug", "message", "listed repositories", "registry", registryName, "repositories", repoCount, "selected", len(reposToSync))
+
+	return reposToSync, nil
+}
+
+func (d *DockerHub) LastModified(repository string) (time.Time, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	t, ok := d.modified[repository]
+	return t, ok
 }
 
 func (d *DockerHub) ListTags(ctx context.Context, repository string) ([]string, error) {
@@ -112,3 +226,65 @@ func (d *DockerHub) ListTags(ctx context.Context, repository string) ([]string,
 
 	return tags, nil
 }
+
+// DeleteTag removes the tag with the Docker Hub API. The manifest and other
+// tags referencing it are kept.
+func (d *DockerHub) DeleteTag(ctx context.Context, repository, tag string) error {
+	if d.token == "" {
+		return microerror.Maskf(executionFailedError, "can not run DeleteTag without calling Authorize first")
+	}
+
+	endpoint := fmt.Sprintf("%s/v2/repositories/%s/tags/%s/", authEndpoint, repository, tag)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.token))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return microerror.Maskf(executionFailedError, "deleting tag %#q failed with status %d", fmt.Sprintf("%s:%s", repository, tag), resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *DockerHub) listRepositoriesForPage(ctx context.Context, endpoint string) (RepositoriesJSON, error) {
+	var repos RepositoriesJSON
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return repos, microerror.Mask(err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.token))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return repos, microerror.Mask(err)
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return repos, microerror.Mask(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return repos, microerror.Maskf(executionFailedError, "listing repositories with %#q failed with status %d", endpoint, resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, &repos)
+	if err != nil {
+		return repos, microerror.Mask(err)
+	}
+
+	return repos, nil
+}
@@ -23,3 +23,12 @@ var invalidConfigError = &microerror.Error{
 func IsInvalidConfig(err error) bool {
 	return microerror.Cause(err) == invalidConfigError
 }
+
+var loginFailedError = &microerror.Error{
+	Kind: "loginFailedError",
+}
+
+// IsLoginFailed asserts loginFailedError.
+func IsLoginFailed(err error) bool {
+	return microerror.Cause(err) == loginFailedError
+}
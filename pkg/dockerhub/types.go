@@ -0,0 +1,14 @@
+package dockerhub
+
+import "time"
+
+type Repository struct {
+	IsPrivate   bool      `json:"is_private"`
+	Name        string    `json:"name"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+type RepositoriesJSON struct {
+	Next    string       `json:"next"`
+	Results []Repository `json:"results"`
+}
@@ -0,0 +1,222 @@
+// Package logger implements micrologger.Logger writing messages as JSON or
+// logfmt text and dropping messages below the configured level.
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+	"github.com/giantswarm/micrologger/loggermeta"
+	kitlog "github.com/go-kit/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+const (
+	LevelDebug   = "debug"
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// levels orders levels by severity.
+var levels = map[string]int{
+	LevelDebug:   0,
+	LevelInfo:    1,
+	LevelWarning: 2,
+	LevelError:   3,
+}
+
+var timestampFormatter kitlog.Valuer = func() interface{} {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.999999-07:00")
+}
+
+type Config struct {
+	// Format is FormatJSON or FormatText. Defaults to FormatJSON.
+	Format string
+	// Level is the lowest level of written messages. Defaults to LevelInfo.
+	Level string
+	// IOWriter defaults to the standard output.
+	IOWriter io.Writer
+}
+
+// Logger writes every message as a JSON object or a logfmt line. Messages
+// without level are written as info. Errors given as "error" value are
+// written as their message together with their kind in "errorKind" value so
+// logs can be filtered by the kind of failure.
+type Logger struct {
+	logger kitlog.Logger
+	level  int
+}
+
+func New(config Config) (*Logger, error) {
+	if config.Format == "" {
+		config.Format = FormatJSON
+	}
+	if config.Level == "" {
+		config.Level = LevelInfo
+	}
+	if config.IOWriter == nil {
+		config.IOWriter = os.Stdout
+	}
+
+	level, ok := levels[config.Level]
+	if !ok {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Level must be one of %#q, %#q, %#q or %#q", config, LevelDebug, LevelInfo, LevelWarning, LevelError)
+	}
+
+	w := kitlog.NewSyncWriter(config.IOWriter)
+
+	var kitLogger kitlog.Logger
+	switch config.Format {
+	case FormatJSON:
+		kitLogger = kitlog.NewJSONLogger(w)
+	case FormatText:
+		kitLogger = kitlog.NewLogfmtLogger(w)
+	default:
+		return nil, microerror.Maskf(invalidConfigError, "%T.Format must be one of %#q or %#q", config, FormatJSON, FormatText)
+	}
+
+	l := &Logger{
+		logger: kitlog.With(kitLogger, "time", timestampFormatter),
+		level:  level,
+	}
+
+	return l, nil
+}
+
+func (l *Logger) Debug(ctx context.Context, message string) {
+	l.LogCtx(ctx, "level", LevelDebug, "message", message)
+}
+
+func (l *Logger) Debugf(ctx context.Context, format string, params ...interface{}) {
+	l.Debug(ctx, fmt.Sprintf(format, params...))
+}
+
+func (l *Logger) Error(ctx context.Context, err error, message string) {
+	if err == nil {
+		l.LogCtx(ctx, "level", LevelError, "message", message)
+		return
+	}
+
+	l.LogCtx(ctx, "level", LevelError, "message", message, "error", err)
+}
+
+func (l *Logger) Errorf(ctx context.Context, err error, format string, params ...interface{}) {
+	l.Error(ctx, err, fmt.Sprintf(format, params...))
+}
+
+func (l *Logger) Log(keyVals ...interface{}) {
+	l.log(keyVals)
+}
+
+// LogCtx works like Log adding key-value pairs of loggermeta.LoggerMeta
+// found in the context and the IDs of the span in the context, if any, so
+// messages can be correlated with traces.
+func (l *Logger) LogCtx(ctx context.Context, keyVals ...interface{}) {
+	meta, ok := loggermeta.FromContext(ctx)
+	if ok {
+		keyVals = append([]interface{}{}, keyVals...)
+		for k, v := range meta.KeyVals {
+			keyVals = append(keyVals, k, v)
+		}
+	}
+
+	sc := trace.SpanContextFromContext(ctx)
+	if sc.IsValid() {
+		keyVals = append(keyVals[:len(keyVals):len(keyVals)], "traceID", sc.TraceID().String(), "spanID", sc.SpanID().String())
+	}
+
+	l.log(keyVals)
+}
+
+func (l *Logger) With(keyVals ...interface{}) micrologger.Logger {
+	return &Logger{
+		logger: kitlog.With(l.logger, expandErrors(keyVals)...),
+		level:  l.level,
+	}
+}
+
+// WithIncreasedCallerDepth returns the logger itself because callers are not
+// logged.
+func (l *Logger) WithIncreasedCallerDepth() micrologger.Logger {
+	return l
+}
+
+func (l *Logger) log(keyVals []interface{}) {
+	level, ok := levelOf(keyVals)
+	if !ok {
+		keyVals = append([]interface{}{"level", LevelInfo}, keyVals...)
+	}
+	if levels[level] < l.level {
+		return
+	}
+
+	err := l.logger.Log(expandErrors(keyVals)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to log with error: %#q, keyVals = %v\n", err.Error(), keyVals)
+	}
+}
+
+// levelOf returns the level of the message. Unknown levels are reported as
+// info.
+func levelOf(keyVals []interface{}) (string, bool) {
+	for i := 1; i < len(keyVals); i += 2 {
+		if keyVals[i-1] != "level" {
+			continue
+		}
+
+		level, ok := keyVals[i].(string)
+		if _, known := levels[level]; !ok || !known {
+			return LevelInfo, true
+		}
+
+		return level, true
+	}
+
+	return LevelInfo, false
+}
+
+// expandErrors replaces the error given as "error" value with its message
+// and adds its kind.
+func expandErrors(keyVals []interface{}) []interface{} {
+	for i := 1; i < len(keyVals); i += 2 {
+		if keyVals[i-1] != "error" {
+			continue
+		}
+
+		err, ok := keyVals[i].(error)
+		if !ok {
+			continue
+		}
+
+		kvs := append([]interface{}{}, keyVals...)
+		kvs[i] = err.Error()
+		kvs = append(kvs, "errorKind", errorKind(err))
+
+		return kvs
+	}
+
+	return keyVals
+}
+
+// errorKind returns the kind of microerror errors and the type of the cause
+// of other errors.
+func errorKind(err error) string {
+	var e *microerror.Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	return fmt.Sprintf("%T", microerror.Cause(err))
+}
@@ -7,44 +7,73 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/giantswarm/microerror"
+	"github.com/giantswarm/micrologger"
+
+	"github.com/giantswarm/crsync/pkg/registry"
 )
 
 const (
+	registryName       = "quay.io"
 	registryEndpoint   = "https://quay.io"
 	repositoryEndpoint = "https://quay.io/api/v1/repository"
 )
 
 type Config struct {
-	Namespace                  string
+	Logger micrologger.Logger
+
+	// Namespaces are Quay organizations or users which repositories are
+	// listed.
+	Namespaces []string
+	// LastModified limits listed repositories to the ones modified within
+	// the duration unless the context was returned by
+	// registry.WithAllRepositories. Zero lists all repositories.
 	LastModified               time.Duration
 	Token                      string
 	IncludePrivateRepositories bool
+	// HTTPClient is optional. A new client is used when nil.
+	HTTPClient *http.Client
 }
 
 type Quay struct {
-	namespace                  string
+	logger micrologger.Logger
+
+	namespaces                 []string
 	lastModified               time.Duration
 	token                      string
 	includePrivateRepositories bool
 
+	mutex sync.Mutex
+	// modified holds when repositories listed by the last
+	// ListRepositories call were last modified.
+	modified map[string]time.Time
+
 	httpClient *http.Client
 }
 
 func New(c Config) (*Quay, error) {
-	httpClient := &http.Client{}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
 
-	if c.Namespace == "" {
-		return nil, microerror.Maskf(invalidConfigError, "%T.Namespace must not be empty", c)
+	if c.Logger == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if len(c.Namespaces) == 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Namespaces must not be empty", c)
 	}
 	if c.IncludePrivateRepositories && c.Token == "" {
 		return nil, microerror.Maskf(invalidConfigError, "%T.Token must not be empty", c)
 	}
 
 	return &Quay{
-		namespace:                  c.Namespace,
+		logger: c.Logger,
+
+		namespaces:                 c.Namespaces,
 		lastModified:               c.LastModified,
 		token:                      c.Token,
 		includePrivateRepositories: c.IncludePrivateRepositories,
@@ -58,81 +87,69 @@ func (q *Quay) Authorize(ctx context.Context, user, password string) error {
 }
 
 func (q *Quay) ListRepositories(ctx context.Context) ([]string, error) {
-	var nextPage string
 	var repoCount int
 	var reposToSync []string
+	// Full syncs ask for all the repositories.
+	all := registry.AllRepositories(ctx)
+	modified := map[string]time.Time{}
 
-	for {
-		repos, err := q.listRepositoriesForPage(ctx, nextPage)
-		if err != nil {
-			return reposToSync, microerror.Mask(err)
-		}
+	for _, namespace := range q.namespaces {
+		var nextPage string
 
-		for _, repo := range repos.Repositories {
-			if !repo.IsPublic && !q.includePrivateRepositories {
-				continue
+		for {
+			repos, err := q.listRepositoriesForPage(ctx, namespace, nextPage)
+			if err != nil {
+				return reposToSync, microerror.Mask(err)
 			}
 
-			lastModifiedTimestamp := time.Now().Add(-1 * q.lastModified).Unix()
-			if int64(repo.LastModified) > lastModifiedTimestamp {
-				reposToSync = append(reposToSync, fmt.Sprintf("%s/%s", q.namespace, repo.Name))
+			for _, repo := range repos.Repositories {
+				if !repo.IsPublic && !q.includePrivateRepositories {
+					continue
+				}
+
+				name := fmt.Sprintf("%s/%s", namespace, repo.Name)
+				if repo.LastModified > 0 {
+					modified[name] = time.Unix(int64(repo.LastModified), 0)
+				}
+
+				lastModifiedTimestamp := time.Now().Add(-1 * q.lastModified).Unix()
+				if q.lastModified == 0 || all || int64(repo.LastModified) > lastModifiedTimestamp {
+					reposToSync = append(reposToSync, name)
+				}
 			}
-		}
-		repoCount += len(repos.Repositories)
+			repoCount += len(repos.Repositories)
 
-		nextPage = repos.NextPage
-		if nextPage == "" {
-			break
+			nextPage = repos.NextPage
+			if nextPage == "" {
+				break
+			}
 		}
 	}
 
-	fmt.Printf("Found %d repositories. Syncing %d\n", repoCount, len(reposToSync))
+	q.mutex.Lock()
+	q.modified = modified
+	q.mutex.Unlock()
+
+	q.logger.LogCtx(ctx, "level", "debug", "message", "listed repositories", "registry", registryName, "repositories", repoCount, "selected", len(reposToSync))
 
 	return reposToSync, nil
 }
 
-func (q *Quay) ListTags(ctx context.Context, repository string) ([]string, error) {
-	endpoint := fmt.Sprintf("%s/api/v1/repository/%s/tag/", registryEndpoint, repository)
-
-	type dataJSON struct {
-		HasAdditional bool `json:"has_additional"`
-		Tags          []struct {
-			Name string `json:"name"`
-		} `json:"tags"`
-	}
+func (q *Quay) LastModified(repository string) (time.Time, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 
-	var tagsData dataJSON
+	t, ok := q.modified[repository]
+	return t, ok
+}
 
+func (q *Quay) ListTags(ctx context.Context, repository string) ([]string, error) {
 	var tags []string
 	{
 		page := 1
 		hasAdditional := true
 		for hasAdditional {
-			req, err := http.NewRequest("GET", endpoint, nil)
-			if err != nil {
-				return nil, microerror.Mask(err)
-			}
-
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", q.token))
-
-			query := req.URL.Query()
-			query.Add("page", strconv.Itoa(page))
-			query.Add("onlyActiveTags", fmt.Sprintf("%t", true))
-
-			req.URL.RawQuery = query.Encode()
-
-			resp, err := q.httpClient.Do(req)
-			if err != nil {
-				return nil, microerror.Mask(err)
-			}
-
-			defer resp.Body.Close()
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return nil, microerror.Mask(err)
-			}
-
-			err = json.Unmarshal(body, &tagsData)
+			tagsData, err := q.listTagsForPage(ctx, repository, page)
 			if err != nil {
 				return nil, microerror.Mask(err)
 			}
@@ -150,10 +167,35 @@ func (q *Quay) ListTags(ctx context.Context, repository string) ([]string, error
 
 }
 
-func (q *Quay) listRepositoriesForPage(ctx context.Context, nextPage string) (RepositoriesJSON, error) {
+// DeleteTag removes the tag with the Quay API. The manifest and other tags
+// referencing it are kept.
+func (q *Quay) DeleteTag(ctx context.Context, repository, tag string) error {
+	endpoint := fmt.Sprintf("%s/api/v1/repository/%s/tag/%s", registryEndpoint, repository, tag)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", q.token))
+
+	resp, err := q.httpClient.Do(req)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return microerror.Maskf(executionFailedError, "deleting tag %#q failed with status %d", fmt.Sprintf("%s:%s", repository, tag), resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (q *Quay) listRepositoriesForPage(ctx context.Context, namespace, nextPage string) (RepositoriesJSON, error) {
 	var repos RepositoriesJSON
 
-	req, err := http.NewRequest("GET", repositoryEndpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", repositoryEndpoint, nil)
 	if err != nil {
 		return repos, microerror.Mask(err)
 	}
@@ -163,7 +205,7 @@ func (q *Quay) listRepositoriesForPage(ctx context.Context, nextPage string) (Re
 	query := req.URL.Query()
 	query.Add("last_modified", "true")
 	query.Add("starred", "false")
-	query.Add("namespace", q.namespace)
+	query.Add("namespace", namespace)
 	if nextPage != "" {
 		query.Add("next_page", nextPage)
 	}
@@ -181,6 +223,10 @@ func (q *Quay) listRepositoriesForPage(ctx context.Context, nextPage string) (Re
 		return repos, microerror.Mask(err)
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return repos, microerror.Maskf(executionFailedError, "listing repositories of namespace %#q failed with status %d", namespace, resp.StatusCode)
+	}
+
 	err = json.Unmarshal(body, &repos)
 	if err != nil {
 		return repos, microerror.Mask(err)
@@ -188,3 +234,48 @@ func (q *Quay) listRepositoriesForPage(ctx context.Context, nextPage string) (Re
 
 	return repos, nil
 }
+
+func (q *Quay) listTagsForPage(ctx context.Context, repository string, page int) (TagsJSON, error) {
+	var tags TagsJSON
+
+	endpoint := fmt.Sprintf("%s/api/v1/repository/%s/tag/", registryEndpoint, repository)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return tags, microerror.Mask(err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", q.token))
+
+	query := req.URL.Query()
+	query.Add("page", strconv.Itoa(page))
+	query.Add("onlyActiveTags", fmt.Sprintf("%t", true))
+
+	req.URL.RawQuery = query.Encode()
+
+	resp, err := q.httpClient.Do(req)
+	if err != nil {
+		return tags, microerror.Mask(err)
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return tags, microerror.Mask(err)
+	}
+
+	// Repositories which do not exist yet have no tags.
+	if resp.StatusCode == http.StatusNotFound {
+		return tags, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return tags, microerror.Maskf(executionFailedError, "listing tags of repository %#q failed with status %d", repository, resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, &tags)
+	if err != nil {
+		return tags, microerror.Mask(err)
+	}
+
+	return tags, nil
+}
@@ -10,3 +10,12 @@ type RepositoriesJSON struct {
 	NextPage     string       `json:"next_page"`
 	Repositories []Repository `json:"repositories"`
 }
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type TagsJSON struct {
+	HasAdditional bool  `json:"has_additional"`
+	Tags          []Tag `json:"tags"`
+}
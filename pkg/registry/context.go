@@ -0,0 +1,19 @@
+package registry
+
+import "context"
+
+type allRepositoriesKey struct{}
+
+// WithAllRepositories returns a context in which ListRepositories lists all
+// the repositories regardless of the last modified window of the registry
+// client.
+func WithAllRepositories(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allRepositoriesKey{}, true)
+}
+
+// AllRepositories returns true when the context was returned by
+// WithAllRepositories.
+func AllRepositories(ctx context.Context) bool {
+	all, _ := ctx.Value(allRepositoriesKey{}).(bool)
+	return all
+}
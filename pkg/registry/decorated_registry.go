@@ -2,25 +2,55 @@ package registry
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"github.com/containers/image/v5/types"
 	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
+
+	"github.com/giantswarm/crsync/pkg/tracing"
 )
 
 type DecoratedRegistryConfig struct {
+	// Concurrency limits the number of image sources and destinations open
+	// at the same time. 0 means unlimited.
+	Concurrency int
 	RateLimiter DecoratedRegistryConfigRateLimiter
-	Underlying  Interface
+	// Throttle adapts the rate limiters to throttling reported by the
+	// registry. It is optional.
+	Throttle *Throttle
+	// OnDone is called after every operation of the underlying registry
+	// with its duration, not including waiting for rate limiters, and its
+	// error. Opening images is reported as pull and push operations. It is
+	// optional.
+	OnDone func(operation string, duration time.Duration, err error)
+	// TracerProvider creates spans for every operation and for waiting
+	// for rate limiters and concurrency slots. It is optional.
+	TracerProvider trace.TracerProvider
+	Underlying     Interface
 }
 
 type DecoratedRegistryConfigRateLimiter struct {
 	ListRepositories *rate.Limiter
 	ListTags         *rate.Limiter
+	Digest           *rate.Limiter
 	Pull             *rate.Limiter
 	Push             *rate.Limiter
+	Delete           *rate.Limiter
 }
 
 type DecoratedRegistry struct {
+	// slots holds a value for every open image source and destination
+	// when concurrency is limited.
+	slots       chan struct{}
 	rateLimiter DecoratedRegistryConfigRateLimiter
+	throttle    *Throttle
+	onDone      func(operation string, duration time.Duration, err error)
+	tracer      trace.Tracer
 	underlying  Interface
 }
 
@@ -31,21 +61,44 @@ func NewDecoratedRegistry(config DecoratedRegistryConfig) (*DecoratedRegistry, e
 	if config.RateLimiter.ListTags == nil {
 		return nil, microerror.Maskf(invalidConfigError, "%T.RateLimiter.ListTags must not be empty", config)
 	}
+	if config.RateLimiter.Digest == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.RateLimiter.Digest must not be empty", config)
+	}
 	if config.RateLimiter.Pull == nil {
 		return nil, microerror.Maskf(invalidConfigError, "%T.RateLimiter.Pull must not be empty", config)
 	}
 	if config.RateLimiter.Push == nil {
 		return nil, microerror.Maskf(invalidConfigError, "%T.RateLimiter.Push must not be empty", config)
 	}
+	if config.RateLimiter.Delete == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.RateLimiter.Delete must not be empty", config)
+	}
 
+	if config.Concurrency < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Concurrency must not be negative", config)
+	}
 	if config.Underlying == nil {
 		return nil, microerror.Maskf(invalidConfigError, "%T.Underlying must not be empty", config)
 	}
 
 	r := &DecoratedRegistry{
 		rateLimiter: config.RateLimiter,
+		throttle:    config.Throttle,
+		onDone:      config.OnDone,
+		tracer:      tracing.Tracer(config.TracerProvider, "github.com/giantswarm/crsync/pkg/registry"),
 		underlying:  config.Underlying,
 	}
+	if config.Concurrency > 0 {
+		r.slots = make(chan struct{}, config.Concurrency)
+	}
+	if r.throttle != nil {
+		r.throttle.Register(OperationListRepositories, r.rateLimiter.ListRepositories)
+		r.throttle.Register(OperationListTags, r.rateLimiter.ListTags)
+		r.throttle.Register(OperationDigest, r.rateLimiter.Digest)
+		r.throttle.Register(OperationPull, r.rateLimiter.Pull)
+		r.throttle.Register(OperationPush, r.rateLimiter.Push)
+		r.throttle.Register(OperationDelete, r.rateLimiter.Delete)
+	}
 
 	return r, nil
 }
@@ -61,12 +114,17 @@ func (r *DecoratedRegistry) Logout(ctx context.Context) error {
 func (r *DecoratedRegistry) ListRepositories(ctx context.Context) ([]string, error) {
 	var err error
 
-	err = r.rateLimiter.ListRepositories.Wait(ctx)
+	ctx, span := r.start(ctx, OperationListRepositories)
+	defer func() { tracing.End(span, err) }()
+
+	err = r.wait(ctx, r.rateLimiter.ListRepositories)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
 
+	start := time.Now()
 	rs, err := r.underlying.ListRepositories(ctx)
+	r.observe(OperationListRepositories, start, err)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
@@ -77,12 +135,17 @@ func (r *DecoratedRegistry) ListRepositories(ctx context.Context) ([]string, err
 func (r *DecoratedRegistry) ListTags(ctx context.Context, repository string) ([]string, error) {
 	var err error
 
-	err = r.rateLimiter.ListTags.Wait(ctx)
+	ctx, span := r.start(ctx, OperationListTags, attribute.String("repository", repository))
+	defer func() { tracing.End(span, err) }()
+
+	err = r.wait(ctx, r.rateLimiter.ListTags)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
 
+	start := time.Now()
 	ts, err := r.underlying.ListTags(ctx, repository)
+	r.observe(OperationListTags, start, err)
 	if err != nil {
 		return nil, microerror.Mask(err)
 	}
@@ -90,47 +153,214 @@ func (r *DecoratedRegistry) ListTags(ctx context.Context, repository string) ([]
 	return ts, nil
 }
 
+func (r *DecoratedRegistry) DeleteTag(ctx context.Context, repository, tag string) error {
+	var err error
+
+	ctx, span := r.start(ctx, OperationDelete, attribute.String("repository", repository), attribute.String("tag", tag))
+	defer func() { tracing.End(span, err) }()
+
+	err = r.wait(ctx, r.rateLimiter.Delete)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	start := time.Now()
+	err = r.underlying.DeleteTag(ctx, repository, tag)
+	r.observe(OperationDelete, start, err)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
 func (r DecoratedRegistry) Name() string {
 	return r.underlying.Name()
 }
 
-func (r *DecoratedRegistry) Pull(ctx context.Context, repo, tag string) error {
+func (r *DecoratedRegistry) LastModified(repository string) (time.Time, bool) {
+	return r.underlying.LastModified(repository)
+}
+
+func (r *DecoratedRegistry) Digest(ctx context.Context, repo, tag string) (digest.Digest, error) {
 	var err error
 
-	err = r.rateLimiter.Pull.Wait(ctx)
+	ctx, span := r.start(ctx, OperationDigest, attribute.String("repository", repo), attribute.String("tag", tag))
+	defer func() { tracing.End(span, err) }()
+
+	err = r.wait(ctx, r.rateLimiter.Digest)
 	if err != nil {
-		return microerror.Mask(err)
+		return "", microerror.Mask(err)
 	}
 
-	err = r.underlying.Pull(ctx, repo, tag)
+	start := time.Now()
+	d, err := r.underlying.Digest(ctx, repo, tag)
+	r.observe(OperationDigest, start, err)
 	if err != nil {
-		return microerror.Mask(err)
+		return "", microerror.Mask(err)
 	}
 
-	return nil
+	return d, nil
 }
 
-func (r *DecoratedRegistry) Push(ctx context.Context, repo, tag string) error {
+// ImageSource waits for a free concurrency slot which is taken until the
+// returned source is closed.
+func (r *DecoratedRegistry) ImageSource(ctx context.Context, repo, tag string) (types.ImageSource, error) {
 	var err error
 
-	err = r.rateLimiter.Push.Wait(ctx)
+	ctx, span := r.start(ctx, OperationPull, attribute.String("repository", repo), attribute.String("tag", tag))
+	defer func() { tracing.End(span, err) }()
+
+	release, err := r.acquire(ctx)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
 
-	err = r.underlying.Push(ctx, repo, tag)
+	err = r.wait(ctx, r.rateLimiter.Pull)
 	if err != nil {
-		return microerror.Mask(err)
+		release()
+		return nil, microerror.Mask(err)
 	}
 
-	return nil
+	start := time.Now()
+	src, err := r.underlying.ImageSource(ctx, repo, tag)
+	r.observe(OperationPull, start, err)
+	if err != nil {
+		release()
+		return nil, microerror.Mask(err)
+	}
+
+	return &limitedImageSource{ImageSource: src, release: release}, nil
+}
+
+// ImageDestination waits for a free concurrency slot which is taken until
+// the returned destination is closed.
+func (r *DecoratedRegistry) ImageDestination(ctx context.Context, repo, tag string) (types.ImageDestination, error) {
+	var err error
+
+	ctx, span := r.start(ctx, OperationPush, attribute.String("repository", repo), attribute.String("tag", tag))
+	defer func() { tracing.End(span, err) }()
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	err = r.wait(ctx, r.rateLimiter.Push)
+	if err != nil {
+		release()
+		return nil, microerror.Mask(err)
+	}
+
+	start := time.Now()
+	dst, err := r.underlying.ImageDestination(ctx, repo, tag)
+	r.observe(OperationPush, start, err)
+	if err != nil {
+		release()
+		return nil, microerror.Mask(err)
+	}
+
+	return &limitedImageDestination{ImageDestination: dst, release: release}, nil
 }
 
-func (r *DecoratedRegistry) RemoveImage(ctx context.Context, repo, tag string) error {
-	err := r.underlying.RemoveImage(ctx, repo, tag)
+// start starts the span of the operation.
+func (r *DecoratedRegistry) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append([]attribute.KeyValue{
+		attribute.String("registry", r.underlying.Name()),
+		attribute.String("operation", operation),
+	}, attrs...)
+
+	return r.tracer.Start(ctx, "registry."+operation, trace.WithAttributes(attrs...))
+}
+
+// wait blocks until the pause requested by the registry is over and the
+// limiter allows the operation.
+func (r *DecoratedRegistry) wait(ctx context.Context, limiter *rate.Limiter) error {
+	var err error
+
+	ctx, span := r.tracer.Start(ctx, "registry.wait")
+	defer func() { tracing.End(span, err) }()
+
+	if r.throttle != nil {
+		err = r.throttle.Wait(ctx)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+
+	err = limiter.Wait(ctx)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
 	return nil
 }
+
+// observe reports the result of the operation started at start. Errors other
+// than throttling are neither a sign of throttling nor of the registry
+// keeping up so the throttle ignores them.
+func (r *DecoratedRegistry) observe(operation string, start time.Time, err error) {
+	if r.onDone != nil {
+		r.onDone(operation, time.Since(start), err)
+	}
+
+	if r.throttle == nil {
+		return
+	}
+
+	if err == nil {
+		r.throttle.Succeeded()
+	} else if IsTooManyRequests(err) {
+		// Responses of registry clients were already observed with
+		// their Retry-After by the round tripper, in which case the
+		// throttle ignores this. Responses of containers/image are
+		// only seen here.
+		r.throttle.Throttled(0)
+	}
+}
+
+// acquire takes a concurrency slot. The returned function releases it and
+// can be called multiple times.
+func (r *DecoratedRegistry) acquire(ctx context.Context) (func(), error) {
+	if r.slots == nil {
+		return func() {}, nil
+	}
+
+	_, span := r.tracer.Start(ctx, "registry.acquireSlot")
+
+	select {
+	case <-ctx.Done():
+		tracing.End(span, ctx.Err())
+		return nil, microerror.Mask(ctx.Err())
+	case r.slots <- struct{}{}:
+	}
+
+	span.End()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { <-r.slots })
+	}
+
+	return release, nil
+}
+
+type limitedImageSource struct {
+	types.ImageSource
+	release func()
+}
+
+func (s *limitedImageSource) Close() error {
+	defer s.release()
+	return s.ImageSource.Close()
+}
+
+type limitedImageDestination struct {
+	types.ImageDestination
+	release func()
+}
+
+func (d *limitedImageDestination) Close() error {
+	defer d.release()
+	return d.ImageDestination.Close()
+}
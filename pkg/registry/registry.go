@@ -2,29 +2,49 @@ package registry
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
-	"os/exec"
 	"strings"
+	"time"
 
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
 	"github.com/giantswarm/microerror"
-)
+	"github.com/opencontainers/go-digest"
 
-const (
-	dockerBinaryName = "docker"
+	"github.com/giantswarm/crsync/pkg/project"
 )
 
 type Config struct {
 	Name           string
 	HttpClient     http.Client
 	RegistryClient RegistryClient
+	// Insecure allows talking to the registry over plain HTTP or HTTPS
+	// without TLS verification.
+	Insecure bool
+	// QuotaProbe is optional. containers/image uses its own HTTP
+	// transport so responses to pulls are not seen by the throttle. When
+	// set, the manifest of every opened image source is requested with
+	// a HEAD request through the probe, which HTTP client observes the
+	// quota reported in the response.
+	QuotaProbe QuotaProbe
+}
+
+// QuotaProbe requests manifests with requests which do not count against the
+// pull quota of the registry.
+type QuotaProbe interface {
+	Authorize(ctx context.Context, user, password string) error
+	ManifestDigest(ctx context.Context, repository, tag string) (digest.Digest, error)
 }
 
 type Registry struct {
-	name string
+	name       string
+	insecure   bool
+	quotaProbe QuotaProbe
 
 	registryClient RegistryClient
+	systemContext  *types.SystemContext
 }
 
 type Repository struct {
@@ -35,16 +55,22 @@ type Repository struct {
 func New(c Config) (*Registry, error) {
 	return &Registry{
 		name:           c.Name,
+		insecure:       c.Insecure,
+		quotaProbe:     c.QuotaProbe,
 		registryClient: c.RegistryClient,
+		systemContext:  newSystemContext(nil, c.Insecure),
 	}, nil
 }
 
 func (r *Registry) Login(ctx context.Context, user, password string) error {
-	args := []string{"login", r.name, "-u", user, "-p", password}
+	sys := newSystemContext(&types.DockerAuthConfig{
+		Username: user,
+		Password: password,
+	}, r.insecure)
 
-	err := executeCmd(dockerBinaryName, args)
+	err := docker.CheckAuth(ctx, sys, user, password, r.name)
 	if err != nil {
-		return microerror.Mask(err)
+		return microerror.Maskf(executionFailedError, "failed to log in to registry %#q with error: %s", r.name, err)
 	}
 
 	err = r.authorize(ctx, user, password)
@@ -52,28 +78,31 @@ func (r *Registry) Login(ctx context.Context, user, password string) error {
 		return microerror.Mask(err)
 	}
 
+	r.systemContext = sys
+
 	return nil
 }
 
 func (r *Registry) Logout(ctx context.Context) error {
-	var args []string
+	r.systemContext = newSystemContext(nil, r.insecure)
 
-	if r.name == "" {
-		args = []string{"logout"}
-	} else {
-		args = []string{"logout", r.name}
-	}
+	return nil
+}
 
-	err := executeCmd(dockerBinaryName, args)
+func (r *Registry) authorize(ctx context.Context, user, password string) error {
+	err := r.registryClient.Authorize(ctx, user, password)
 	if err != nil {
 		return microerror.Mask(err)
 	}
 
-	return nil
-}
+	if r.quotaProbe != nil {
+		err = r.quotaProbe.Authorize(ctx, user, password)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+	}
 
-func (r *Registry) authorize(ctx context.Context, user, password string) error {
-	return r.registryClient.Authorize(ctx, user, password)
+	return nil
 }
 
 func (r *Registry) ListRepositories(ctx context.Context) ([]string, error) {
@@ -84,69 +113,88 @@ func (r *Registry) ListTags(ctx context.Context, repository string) ([]string, e
 	return r.registryClient.ListTags(ctx, repository)
 }
 
+func (r *Registry) DeleteTag(ctx context.Context, repository, tag string) error {
+	return r.registryClient.DeleteTag(ctx, repository, tag)
+}
+
 func (r Registry) Name() string {
 	return r.name
 }
 
-func (r *Registry) Pull(ctx context.Context, repo, tag string) error {
-	image := fmt.Sprintf("%s/%s:%s", r.name, repo, tag)
-
-	args := []string{"pull", image}
-
-	err := executeCmd(dockerBinaryName, args)
-	if err != nil {
-		return microerror.Mask(err)
+func (r *Registry) LastModified(repository string) (time.Time, bool) {
+	c, ok := r.registryClient.(LastModifiedClient)
+	if !ok {
+		return time.Time{}, false
 	}
 
-	return nil
+	return c.LastModified(repository)
 }
 
-func (r *Registry) Push(ctx context.Context, repo, tag string) error {
-	image := fmt.Sprintf("%s/%s:%s", r.name, repo, tag)
-
-	args := []string{"push", image}
+func (r *Registry) Digest(ctx context.Context, repo, tag string) (digest.Digest, error) {
+	ref, err := r.reference(repo, tag)
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
 
-	err := executeCmd(dockerBinaryName, args)
+	d, err := docker.GetDigest(ctx, r.systemContext, ref)
 	if err != nil {
-		return microerror.Mask(err)
+		return "", microerror.Maskf(executionFailedError, "failed to get digest of %#q with error: %s", ref.StringWithinTransport(), err)
 	}
 
-	return nil
+	return d, nil
 }
 
-func (r *Registry) RemoveImage(ctx context.Context, repo, tag string) error {
-	image := fmt.Sprintf("%s/%s:%s", r.name, repo, tag)
+func (r *Registry) ImageSource(ctx context.Context, repo, tag string) (types.ImageSource, error) {
+	ref, err := r.reference(repo, tag)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
 
-	isRunning, err := imageIsRunning(repo, tag)
+	src, err := ref.NewImageSource(ctx, r.systemContext)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Maskf(executionFailedError, "failed to open image source %#q with error: %s", ref.StringWithinTransport(), err)
 	}
-	if isRunning {
-		return nil
+
+	if r.quotaProbe != nil {
+		// The probe only feeds the throttle so its errors are not
+		// errors of the pull.
+		_, _ = r.quotaProbe.ManifestDigest(ctx, repo, tag)
 	}
 
-	args := []string{"rmi", image}
+	return src, nil
+}
 
-	err = executeCmd(dockerBinaryName, args)
+func (r *Registry) ImageDestination(ctx context.Context, repo, tag string) (types.ImageDestination, error) {
+	ref, err := r.reference(repo, tag)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
 
-	return nil
+	dst, err := ref.NewImageDestination(ctx, r.systemContext)
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to open image destination %#q with error: %s", ref.StringWithinTransport(), err)
+	}
+
+	return dst, nil
 }
 
-func RetagImage(repo, tag, srcRegistry, dstRegistry string) error {
-	srcImage := fmt.Sprintf("%s/%s:%s", srcRegistry, repo, tag)
-	dstImage := fmt.Sprintf("%s/%s:%s", dstRegistry, repo, tag)
+func (r *Registry) reference(repo, tag string) (types.ImageReference, error) {
+	named, err := reference.ParseNormalizedNamed(fmt.Sprintf("%s/%s", r.name, repo))
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to parse repository %#q with error: %s", repo, err)
+	}
 
-	args := []string{"tag", srcImage, dstImage}
+	tagged, err := reference.WithTag(named, tag)
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to parse tag %#q with error: %s", tag, err)
+	}
 
-	err := executeCmd(dockerBinaryName, args)
+	ref, err := docker.NewReference(tagged)
 	if err != nil {
-		return microerror.Mask(err)
+		return nil, microerror.Maskf(executionFailedError, "failed to create reference for %#q with error: %s", tagged, err)
 	}
 
-	return nil
+	return ref, nil
 }
 
 func GetLink(linkHeader string) string {
@@ -164,40 +212,31 @@ func GetLink(linkHeader string) string {
 	return linkHeader[s:e]
 }
 
-func imageIsRunning(repo, tag string) (bool, error) {
-	cmd := exec.Command(dockerBinaryName, "ps")
-
-	var exitCode int = -1
-	var exitErr *exec.ExitError
-
-	output, err := cmd.CombinedOutput()
-	if errors.As(err, &exitErr) {
-		exitCode = exitErr.ExitCode()
+// InNamespaces returns true when the repository is nested in one of the given
+// namespaces or when no namespaces are given.
+func InNamespaces(repository string, namespaces []string) bool {
+	if len(namespaces) == 0 {
+		return true
 	}
-	if err != nil {
-		return false, microerror.Maskf(executionFailedError, "command execution failed with exit code = %d error = %#q and output:\n\n%s", exitCode, err, output)
+
+	for _, n := range namespaces {
+		if strings.HasPrefix(repository, strings.TrimSuffix(n, "/")+"/") {
+			return true
+		}
 	}
 
-	imageName := fmt.Sprintf("%s:%s", repo, tag)
-	return strings.Contains(string(output), imageName), nil
+	return false
 }
 
-func executeCmd(binary string, args []string) error {
-	cmd := exec.Command(
-		binary,
-		args...,
-	)
-
-	var exitCode int = -1
-	var exitErr *exec.ExitError
-
-	output, err := cmd.CombinedOutput()
-	if errors.As(err, &exitErr) {
-		exitCode = exitErr.ExitCode()
+func newSystemContext(auth *types.DockerAuthConfig, insecure bool) *types.SystemContext {
+	sys := &types.SystemContext{
+		DockerAuthConfig:        auth,
+		DockerRegistryUserAgent: fmt.Sprintf("%s/%s", project.Name(), project.Version()),
 	}
-	if err != nil {
-		return microerror.Maskf(executionFailedError, "command execution failed with exit code = %d error = %#q and output:\n\n%s", exitCode, err, output)
+
+	if insecure {
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
 	}
 
-	return nil
+	return sys
 }
@@ -1,9 +1,22 @@
 package registry
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type RegistryClient interface {
 	Authorize(ctx context.Context, user, password string) error
 	ListRepositories(ctx context.Context) ([]string, error)
 	ListTags(ctx context.Context, repositry string) ([]string, error)
+	DeleteTag(ctx context.Context, repository, tag string) error
+}
+
+// LastModifiedClient is implemented by registry clients which learn when
+// repositories were last modified from listing them.
+type LastModifiedClient interface {
+	// LastModified returns when the repository was last modified according
+	// to the last ListRepositories call. It returns false for repositories
+	// which were not listed by it.
+	LastModified(repository string) (time.Time, bool)
 }
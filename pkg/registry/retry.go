@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// permanentErrorPatterns match errors which fail again when retried,
+	// e.g. 401, 404 or an invalid manifest. They take precedence over
+	// retryable ones.
+	permanentErrorPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\bstatus(code)?:? (400|401|403|404|405)\b`),
+		regexp.MustCompile(`\b(unauthorized|denied|forbidden|not found)\b`),
+		regexp.MustCompile(`\b(manifest|blob|name) unknown\b`),
+		regexp.MustCompile(`\b(manifest|name|tag|digest) invalid\b`),
+		regexp.MustCompile(`\bunsupported\b`),
+	}
+	// retryableErrorPatterns match errors which are likely transient, e.g.
+	// 5xx, 429, timeouts and broken connections.
+	retryableErrorPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\bstatus(code)?:? (429|5\d\d)\b`),
+		regexp.MustCompile(`too many requests|toomanyrequests`),
+		regexp.MustCompile(`internal server error|bad gateway|service unavailable|gateway timeout`),
+		regexp.MustCompile(`\b(timeout|timed out)\b`),
+		regexp.MustCompile(`connection (reset|refused)|broken pipe|\beof\b|no such host`),
+	}
+)
+
+// IsRetryable returns true when the error is likely transient so the
+// operation can succeed when retried. Errors of registry clients and
+// containers/image are matched by message because they are not wrapped.
+// Unknown errors are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, context.Canceled.Error()) {
+		return false
+	}
+	for _, p := range permanentErrorPatterns {
+		if p.MatchString(msg) {
+			return false
+		}
+	}
+	for _, p := range retryableErrorPatterns {
+		if p.MatchString(msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryPolicy retries registry operations failing with retryable errors
+// with exponential backoff.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts per operation. Operations
+	// which are not set are attempted once.
+	Attempts map[string]int
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry up to MaxBackoff, without limit when it is 0. Every delay is randomized between its half
+	// and its full length so retries of parallel operations spread out.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// OnRetry is called before every retry with the context of the
+	// operation, the number of the coming attempt and the error of the
+	// previous one. It is optional.
+	OnRetry func(ctx context.Context, operation string, attempt int, err error)
+}
+
+// Do calls f until it succeeds, fails with an error which is not retryable
+// or the attempts of the operation are used up. The last error is returned.
+func (p RetryPolicy) Do(ctx context.Context, operation string, f func() error) error {
+	return p.Retry(ctx, operation, f(), f)
+}
+
+// Retry works like Do when the first attempt was already made and failed
+// with err. Every retry is recorded as an event of the span in ctx.
+func (p RetryPolicy) Retry(ctx context.Context, operation string, err error, f func() error) error {
+	attempts := p.Attempts[operation]
+
+	for attempt := 2; attempt <= attempts && IsRetryable(err); attempt++ {
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.String("operation", operation),
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error()),
+		))
+		if p.OnRetry != nil {
+			p.OnRetry(ctx, operation, attempt, err)
+		}
+
+		timer := time.NewTimer(p.backoff(attempt - 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return microerror.Mask(err)
+		case <-timer.C:
+		}
+
+		err = f()
+	}
+
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// backoff returns the jittered delay before the given retry counted from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && d > 0 && d <= math.MaxInt64/2; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,239 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o deadline reached" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_IsRetryable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "case 0: no error",
+			err:      nil,
+			expected: false,
+		},
+		{
+			name:     "case 1: 429 reported by containers/image",
+			err:      errors.New("reading manifest v1.0.0 in quay.io/giantswarm/app-operator: too many requests to registry"),
+			expected: true,
+		},
+		{
+			name:     "case 2: Docker Hub pull rate limit",
+			err:      errors.New("reading manifest latest in docker.io/giantswarm/app-operator: toomanyrequests: You have reached your pull rate limit. You may increase the limit by authenticating and upgrading: https://www.docker.com/increase-rate-limit"),
+			expected: true,
+		},
+		{
+			name:     "case 3: 503 with unexpected body",
+			err:      errors.New(`writing manifest: uploading manifest v1.0.0 to giantswarm/app-operator: StatusCode: 503, "<html><body><h1>503 Service Unavailable</h1>..."`),
+			expected: true,
+		},
+		{
+			name:     "case 4: 502 without error body",
+			err:      errors.New("pinging container registry gsoci.azurecr.io: invalid status code from registry 502 (Bad Gateway)"),
+			expected: true,
+		},
+		{
+			name:     "case 5: 500 of registry client",
+			err:      microerror.Maskf(executionFailedError, "listing tags of repository %#q failed with status %d", "giantswarm/app-operator", 500),
+			expected: true,
+		},
+		{
+			name:     "case 6: EOF",
+			err:      errors.New(`reading blob sha256:0123: Get "https://quay.io/v2/giantswarm/app-operator/blobs/sha256:0123": EOF`),
+			expected: true,
+		},
+		{
+			name:     "case 7: unexpected EOF while copying",
+			err:      errors.New("writing blob: storing blob to file: happened during read: unexpected EOF"),
+			expected: true,
+		},
+		{
+			name:     "case 8: connection reset",
+			err:      errors.New(`Get "https://quay.io/v2/": read tcp 10.0.0.1:43210->3.1.2.3:443: read: connection reset by peer`),
+			expected: true,
+		},
+		{
+			name:     "case 9: TLS handshake timeout",
+			err:      errors.New(`pinging container registry quay.io: Get "https://quay.io/v2/": net/http: TLS handshake timeout`),
+			expected: true,
+		},
+		{
+			name:     "case 10: network timeout",
+			err:      fmt.Errorf("reading blob: %w", timeoutError{}),
+			expected: true,
+		},
+		{
+			name:     "case 11: 401 with invalid credentials",
+			err:      errors.New("unable to retrieve auth token: invalid username/password: unauthorized: incorrect username or password"),
+			expected: false,
+		},
+		{
+			name:     "case 12: 401 with unexpected body",
+			err:      errors.New(`reading manifest v1.0.0 in quay.io/giantswarm/app-operator: StatusCode: 401, "Unauthorized"`),
+			expected: false,
+		},
+		{
+			name:     "case 13: denied",
+			err:      errors.New("reading manifest v1.0.0 in docker.io/giantswarm/private: requested access to the resource is denied"),
+			expected: false,
+		},
+		{
+			name:     "case 14: 404 manifest unknown",
+			err:      errors.New("reading manifest v9.9.9 in quay.io/giantswarm/app-operator: manifest unknown"),
+			expected: false,
+		},
+		{
+			name:     "case 15: 404 without error body",
+			err:      errors.New("invalid status code from registry 404 (Not Found)"),
+			expected: false,
+		},
+		{
+			name:     "case 16: 404 of registry client",
+			err:      errors.New("listing `giantswarm/app-operator` failed with status 404"),
+			expected: false,
+		},
+		{
+			name:     "case 17: canceled",
+			err:      fmt.Errorf("reading blob: %w", context.Canceled),
+			expected: false,
+		},
+		{
+			name:     "case 18: unknown error",
+			err:      errors.New("something went wrong"),
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			retryable := IsRetryable(tc.err)
+			if retryable != tc.expected {
+				t.Fatalf("IsRetryable(%v) == %t, want %t", tc.err, retryable, tc.expected)
+			}
+		})
+	}
+}
+
+func Test_RetryPolicy_backoff(t *testing.T) {
+	testCases := []struct {
+		name       string
+		backoff    time.Duration
+		maxBackoff time.Duration
+		retry      int
+		expected   time.Duration
+	}{
+		{
+			name:     "case 0: first retry waits backoff",
+			backoff:  time.Second,
+			retry:    1,
+			expected: time.Second,
+		},
+		{
+			name:       "case 1: backoff doubles with every retry",
+			backoff:    time.Second,
+			maxBackoff: time.Minute,
+			retry:      4,
+			expected:   8 * time.Second,
+		},
+		{
+			name:       "case 2: backoff is capped",
+			backoff:    time.Second,
+			maxBackoff: 5 * time.Second,
+			retry:      4,
+			expected:   5 * time.Second,
+		},
+		{
+			name:     "case 3: backoff keeps doubling without cap",
+			backoff:  time.Second,
+			retry:    6,
+			expected: 32 * time.Second,
+		},
+		{
+			name:     "case 4: no backoff",
+			retry:    3,
+			expected: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := RetryPolicy{
+				Backoff:    tc.backoff,
+				MaxBackoff: tc.maxBackoff,
+			}
+
+			// The delay is randomized between its half and its full
+			// length.
+			for i := 0; i < 100; i++ {
+				d := p.backoff(tc.retry)
+				if d < tc.expected/2 || d > tc.expected {
+					t.Fatalf("backoff(%d) == %s, want between %s and %s", tc.retry, d, tc.expected/2, tc.expected)
+				}
+			}
+		})
+	}
+}
+
+func Test_RetryPolicy_Do(t *testing.T) {
+	testCases := []struct {
+		name     string
+		errs     []error
+		attempts int
+		expected int
+	}{
+		{
+			name:     "case 0: retryable errors are retried",
+			errs:     []error{errors.New("EOF"), errors.New("EOF")},
+			attempts: 3,
+			expected: 3,
+		},
+		{
+			name:     "case 1: permanent errors are not retried",
+			errs:     []error{errors.New("manifest unknown")},
+			attempts: 3,
+			expected: 1,
+		},
+		{
+			name:     "case 2: attempts are limited",
+			errs:     []error{errors.New("EOF"), errors.New("EOF"), errors.New("EOF"), errors.New("EOF")},
+			attempts: 3,
+			expected: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := RetryPolicy{
+				Attempts: map[string]int{OperationPull: tc.attempts},
+				Backoff:  time.Millisecond,
+			}
+
+			var calls int
+			_ = p.Do(context.Background(), OperationPull, func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tc.expected {
+				t.Fatalf("calls == %d, want %d", calls, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/giantswarm/microerror"
+	"github.com/opencontainers/go-digest"
+)
+
+type RetryingRegistryConfig struct {
+	Policy     RetryPolicy
+	Underlying Interface
+}
+
+// RetryingRegistry retries operations of the underlying registry failing
+// with retryable errors. Images are read and written after ImageSource and
+// ImageDestination return so only opening them is retried here.
+type RetryingRegistry struct {
+	policy     RetryPolicy
+	underlying Interface
+}
+
+func NewRetryingRegistry(config RetryingRegistryConfig) (*RetryingRegistry, error) {
+	if config.Policy.Backoff < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Policy.Backoff must not be negative", config)
+	}
+	if config.Policy.MaxBackoff < 0 {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Policy.MaxBackoff must not be negative", config)
+	}
+	if config.Underlying == nil {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Underlying must not be empty", config)
+	}
+
+	r := &RetryingRegistry{
+		policy:     config.Policy,
+		underlying: config.Underlying,
+	}
+
+	return r, nil
+}
+
+func (r *RetryingRegistry) Login(ctx context.Context, user, password string) error {
+	return microerror.Mask(r.underlying.Login(ctx, user, password))
+}
+
+func (r *RetryingRegistry) Logout(ctx context.Context) error {
+	return microerror.Mask(r.underlying.Logout(ctx))
+}
+
+func (r *RetryingRegistry) ListRepositories(ctx context.Context) ([]string, error) {
+	var rs []string
+
+	err := r.policy.Do(ctx, OperationListRepositories, func() error {
+		var err error
+		rs, err = r.underlying.ListRepositories(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return rs, nil
+}
+
+func (r *RetryingRegistry) ListTags(ctx context.Context, repository string) ([]string, error) {
+	var ts []string
+
+	err := r.policy.Do(ctx, OperationListTags, func() error {
+		var err error
+		ts, err = r.underlying.ListTags(ctx, repository)
+		return err
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return ts, nil
+}
+
+func (r *RetryingRegistry) DeleteTag(ctx context.Context, repository, tag string) error {
+	err := r.policy.Do(ctx, OperationDelete, func() error {
+		return r.underlying.DeleteTag(ctx, repository, tag)
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (r RetryingRegistry) Name() string {
+	return r.underlying.Name()
+}
+
+func (r *RetryingRegistry) LastModified(repository string) (time.Time, bool) {
+	return r.underlying.LastModified(repository)
+}
+
+func (r *RetryingRegistry) Digest(ctx context.Context, repo, tag string) (digest.Digest, error) {
+	var d digest.Digest
+
+	err := r.policy.Do(ctx, OperationDigest, func() error {
+		var err error
+		d, err = r.underlying.Digest(ctx, repo, tag)
+		return err
+	})
+	if err != nil {
+		return "", microerror.Mask(err)
+	}
+
+	return d, nil
+}
+
+func (r *RetryingRegistry) ImageSource(ctx context.Context, repo, tag string) (types.ImageSource, error) {
+	var src types.ImageSource
+
+	err := r.policy.Do(ctx, OperationPull, func() error {
+		var err error
+		src, err = r.underlying.ImageSource(ctx, repo, tag)
+		return err
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return src, nil
+}
+
+func (r *RetryingRegistry) ImageDestination(ctx context.Context, repo, tag string) (types.ImageDestination, error) {
+	var dst types.ImageDestination
+
+	err := r.policy.Do(ctx, OperationPush, func() error {
+		var err error
+		dst, err = r.underlying.ImageDestination(ctx, repo, tag)
+		return err
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return dst, nil
+}
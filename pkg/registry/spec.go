@@ -1,14 +1,45 @@
 package registry
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
 
 type Interface interface {
 	Login(ctx context.Context, user, password string) error
 	Logout(ctx context.Context) error
 	ListRepositories(ctx context.Context) ([]string, error)
 	ListTags(ctx context.Context, repository string) ([]string, error)
+	// DeleteTag removes the tag from the repository. Registries which can
+	// only delete manifests by digest also remove other tags pointing to the
+	// same manifest, so callers must not delete tags sharing the manifest
+	// with tags they keep.
+	DeleteTag(ctx context.Context, repository, tag string) error
 	Name() string
-	Pull(ctx context.Context, repo, tag string) error
-	Push(ctx context.Context, repo, tag string) error
-	RemoveImage(ctx context.Context, repo, tag string) error
+	// LastModified returns when the repository was last modified according
+	// to the last ListRepositories call. It returns false when the registry
+	// does not report it or the repository was not listed by that call.
+	LastModified(repository string) (time.Time, bool)
+	// Digest returns the digest of the manifest tagged as repo:tag.
+	Digest(ctx context.Context, repo, tag string) (digest.Digest, error)
+	// ImageSource opens the image repo:tag for reading manifests and blobs
+	// directly from the registry.
+	ImageSource(ctx context.Context, repo, tag string) (types.ImageSource, error)
+	// ImageDestination opens the image repo:tag for writing manifests and
+	// blobs directly to the registry.
+	ImageDestination(ctx context.Context, repo, tag string) (types.ImageDestination, error)
 }
+
+// Names of registry operations used in rate limits, retry policies and
+// metrics.
+const (
+	OperationListRepositories = "listRepositories"
+	OperationListTags         = "listTags"
+	OperationDigest           = "digest"
+	OperationPull             = "pull"
+	OperationPush             = "push"
+	OperationDelete           = "delete"
+)
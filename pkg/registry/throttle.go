@@ -0,0 +1,382 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// throttleMinFactor is the lowest fraction of configured rates
+	// operations are slowed down to.
+	throttleMinFactor = 0.05
+	// throttleRecoveryStep is the fraction of configured rates restored
+	// after every successful operation.
+	throttleRecoveryStep = 0.05
+	// throttleLowQuota is the fraction of the quota reported by the
+	// registry below which operations are slowed down proportionally.
+	throttleLowQuota = 0.1
+	// defaultRetryAfter is the pause after being throttled when the
+	// registry does not send Retry-After header.
+	defaultRetryAfter = 10 * time.Second
+)
+
+var tooManyRequestsPattern = regexp.MustCompile(`too many requests|toomanyrequests|\bstatus(code)?:? 429\b`)
+
+type ThrottleConfig struct {
+	// OnChange is called with the new state every time it changes. Calls
+	// are serialized and states older than the last reported one are
+	// skipped. It is optional.
+	OnChange func(ThrottleState)
+}
+
+// ThrottleState is the current throttling of a registry.
+type ThrottleState struct {
+	// Factor is the fraction of configured rates operations are limited
+	// to.
+	Factor float64
+	// Rates are effective rates of registered operations in operations
+	// per second.
+	Rates map[string]float64
+	// Remaining and Limit are the quota reported by the registry in
+	// RateLimit headers. They are -1 when unknown.
+	Remaining int64
+	Limit     int64
+	// BlockedUntil is the time until which no operation is started.
+	BlockedUntil time.Time
+}
+
+// Throttle adapts rate limiters of a registry to throttling reported by the
+// registry. Rates are halved and operations are paused for Retry-After when
+// the registry responds with 429 Too Many Requests. Rates are also lowered
+// when the quota reported in RateLimit headers is close to being exhausted.
+// Every successful operation restores a part of the configured rates.
+type Throttle struct {
+	onChange func(ThrottleState)
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	mutex sync.Mutex
+	// factor is lowered by 429 responses and restored by successful
+	// operations.
+	factor float64
+	// quotaFactor is the factor derived from the remaining quota.
+	quotaFactor  float64
+	remaining    int64
+	limit        int64
+	blockedUntil time.Time
+	limiters     map[string]throttledLimiter
+	// version is incremented on every change of the state.
+	version uint64
+
+	// notifyMutex serializes calls of onChange. notified is the version
+	// of the last reported state.
+	notifyMutex sync.Mutex
+	notified    uint64
+}
+
+type throttledLimiter struct {
+	limiter *rate.Limiter
+	base    rate.Limit
+}
+
+func NewThrottle(c ThrottleConfig) (*Throttle, error) {
+	t := &Throttle{
+		onChange: c.OnChange,
+		now:      time.Now,
+
+		factor:      1,
+		quotaFactor: 1,
+		remaining:   -1,
+		limit:       -1,
+		limiters:    map[string]throttledLimiter{},
+	}
+
+	return t, nil
+}
+
+// Register makes the throttle adapt the limiter of the operation. The current
+// limit of the limiter is the configured rate.
+func (t *Throttle) Register(operation string, l *rate.Limiter) {
+	t.mutex.Lock()
+
+	t.limiters[operation] = throttledLimiter{
+		limiter: l,
+		base:    l.Limit(),
+	}
+
+	s, version := t.changed()
+	t.mutex.Unlock()
+
+	t.notify(s, version)
+}
+
+// Wait blocks until the pause requested by the registry is over.
+func (t *Throttle) Wait(ctx context.Context) error {
+	t.mutex.Lock()
+	d := t.blockedUntil.Sub(t.now())
+	t.mutex.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return microerror.Mask(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Throttled halves the rates and pauses operations for retryAfter or
+// a default pause when it is zero. Rates are halved once per pause because
+// concurrent operations are usually throttled together. Throttling without
+// Retry-After reported during a pause is ignored, because it is usually the
+// same response already observed by the round tripper seen again as an error
+// of the operation.
+func (t *Throttle) Throttled(retryAfter time.Duration) {
+	t.mutex.Lock()
+
+	now := t.now()
+	if retryAfter <= 0 {
+		if now.Before(t.blockedUntil) {
+			t.mutex.Unlock()
+			return
+		}
+		retryAfter = defaultRetryAfter
+	}
+
+	if now.After(t.blockedUntil) {
+		t.factor /= 2
+		if t.factor < throttleMinFactor {
+			t.factor = throttleMinFactor
+		}
+	}
+	if until := now.Add(retryAfter); until.After(t.blockedUntil) {
+		t.blockedUntil = until
+	}
+
+	s, version := t.changed()
+	t.mutex.Unlock()
+
+	t.notify(s, version)
+}
+
+// Succeeded restores a part of the configured rates.
+func (t *Throttle) Succeeded() {
+	t.mutex.Lock()
+
+	if t.factor >= 1 {
+		t.mutex.Unlock()
+		return
+	}
+
+	t.factor += throttleRecoveryStep
+	if t.factor > 1 {
+		t.factor = 1
+	}
+
+	s, version := t.changed()
+	t.mutex.Unlock()
+
+	t.notify(s, version)
+}
+
+// Observe adapts the rates to the response of the registry.
+func (t *Throttle) Observe(resp *http.Response) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		t.Throttled(parseRetryAfter(resp.Header.Get("Retry-After"), t.now()))
+		return
+	}
+
+	limit, limitOK := parseRateLimitHeader(resp.Header, "Limit")
+	remaining, remainingOK := parseRateLimitHeader(resp.Header, "Remaining")
+	if !limitOK || !remainingOK || limit <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+
+	t.limit = limit
+	t.remaining = remaining
+
+	t.quotaFactor = 1
+	if ratio := float64(remaining) / float64(limit); ratio < throttleLowQuota {
+		t.quotaFactor = ratio / throttleLowQuota
+		if t.quotaFactor < throttleMinFactor {
+			t.quotaFactor = throttleMinFactor
+		}
+	}
+
+	if remaining == 0 {
+		reset, ok := parseRateLimitHeader(resp.Header, "Reset")
+		if ok && reset > 0 {
+			until := t.now().Add(time.Duration(reset) * time.Second)
+			// Some registries send the reset time as Unix timestamp.
+			if reset > 1e9 {
+				until = time.Unix(reset, 0)
+			}
+			if until.After(t.blockedUntil) {
+				t.blockedUntil = until
+			}
+		}
+	}
+
+	s, version := t.changed()
+	t.mutex.Unlock()
+
+	t.notify(s, version)
+}
+
+// State returns the current throttling.
+func (t *Throttle) State() ThrottleState {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.state()
+}
+
+// RoundTripper returns a round tripper observing all responses of the
+// registry.
+func (t *Throttle) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	return &throttleRoundTripper{
+		base:     base,
+		throttle: t,
+	}
+}
+
+func (t *Throttle) effectiveFactor() float64 {
+	if t.quotaFactor < t.factor {
+		return t.quotaFactor
+	}
+
+	return t.factor
+}
+
+// changed applies the effective factor to the limiters and returns the new
+// state and its version to be reported with notify. It must be called with the
+// mutex locked.
+func (t *Throttle) changed() (ThrottleState, uint64) {
+	f := t.effectiveFactor()
+	for _, l := range t.limiters {
+		l.limiter.SetLimit(rate.Limit(float64(l.base) * f))
+	}
+
+	t.version++
+
+	return t.state(), t.version
+}
+
+// notify reports the state unless a newer one was reported already. It must
+// be called with the mutex unlocked so OnChange can call State and does not
+// block operations.
+func (t *Throttle) notify(s ThrottleState, version uint64) {
+	if t.onChange == nil {
+		return
+	}
+
+	t.notifyMutex.Lock()
+	defer t.notifyMutex.Unlock()
+
+	if version <= t.notified {
+		return
+	}
+	t.notified = version
+
+	t.onChange(s)
+}
+
+func (t *Throttle) state() ThrottleState {
+	s := ThrottleState{
+		Factor:       t.effectiveFactor(),
+		Rates:        map[string]float64{},
+		Remaining:    t.remaining,
+		Limit:        t.limit,
+		BlockedUntil: t.blockedUntil,
+	}
+	for operation, l := range t.limiters {
+		s.Rates[operation] = float64(l.limiter.Limit())
+	}
+
+	return s
+}
+
+type throttleRoundTripper struct {
+	base     http.RoundTripper
+	throttle *Throttle
+}
+
+func (rt *throttleRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.throttle.Observe(resp)
+
+	return resp, nil
+}
+
+// IsTooManyRequests returns true when the error was caused by the registry
+// throttling requests. Errors of containers/image are matched by message
+// because they are not wrapped.
+func IsTooManyRequests(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return tooManyRequestsPattern.MatchString(strings.ToLower(err.Error()))
+}
+
+// parseRetryAfter parses Retry-After header given in seconds or as HTTP
+// date relative to now. It returns zero when the header is missing or
+// invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	t, err := http.ParseTime(value)
+	if err == nil {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// parseRateLimitHeader parses RateLimit-<name> or X-RateLimit-<name> header.
+// Values can have parameters, e.g. Docker Hub sends "100;w=21600".
+func parseRateLimitHeader(header http.Header, name string) (int64, bool) {
+	value := header.Get("RateLimit-" + name)
+	if value == "" {
+		value = header.Get("X-RateLimit-" + name)
+	}
+	if value == "" {
+		return 0, false
+	}
+
+	value, _, _ = strings.Cut(value, ";")
+
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
@@ -0,0 +1,282 @@
+package registry
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var testNow = time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+
+// newTestThrottle returns a throttle with a limiter of 10 operations per
+// second registered for pulls and a function moving its clock.
+func newTestThrottle(t *testing.T, onChange func(ThrottleState)) (*Throttle, *rate.Limiter, func(time.Duration)) {
+	tr, err := NewThrottle(ThrottleConfig{
+		OnChange: onChange,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := testNow
+	tr.now = func() time.Time { return now }
+	advance := func(d time.Duration) { now = now.Add(d) }
+
+	l := rate.NewLimiter(10, 1)
+	tr.Register(OperationPull, l)
+
+	return tr, l, advance
+}
+
+func assertFactor(t *testing.T, tr *Throttle, l *rate.Limiter, expected float64) {
+	t.Helper()
+
+	s := tr.State()
+	if math.Abs(s.Factor-expected) > 1e-9 {
+		t.Fatalf("factor == %v, want %v", s.Factor, expected)
+	}
+	if limit := float64(l.Limit()); math.Abs(limit-10*expected) > 1e-9 {
+		t.Fatalf("limit == %v, want %v", limit, 10*expected)
+	}
+}
+
+func assertBlockedUntil(t *testing.T, tr *Throttle, expected time.Time) {
+	t.Helper()
+
+	if s := tr.State(); !s.BlockedUntil.Equal(expected) {
+		t.Fatalf("blocked until %s, want %s", s.BlockedUntil, expected)
+	}
+}
+
+func Test_Throttle_Throttled(t *testing.T) {
+	tr, l, advance := newTestThrottle(t, nil)
+
+	tr.Throttled(30 * time.Second)
+	assertFactor(t, tr, l, 0.5)
+	assertBlockedUntil(t, tr, testNow.Add(30*time.Second))
+
+	// Rates are halved once per pause but the pause is extended.
+	advance(time.Second)
+	tr.Throttled(60 * time.Second)
+	assertFactor(t, tr, l, 0.5)
+	assertBlockedUntil(t, tr, testNow.Add(61*time.Second))
+
+	// Throttling without Retry-After during the pause is ignored.
+	advance(time.Second)
+	tr.Throttled(0)
+	assertFactor(t, tr, l, 0.5)
+	assertBlockedUntil(t, tr, testNow.Add(61*time.Second))
+
+	// Throttling without Retry-After after the pause pauses for the
+	// default time.
+	advance(time.Minute)
+	tr.Throttled(0)
+	assertFactor(t, tr, l, 0.25)
+	assertBlockedUntil(t, tr, testNow.Add(62*time.Second+defaultRetryAfter))
+
+	// Rates are never lowered below the minimum.
+	for i := 0; i < 10; i++ {
+		advance(time.Hour)
+		tr.Throttled(time.Second)
+	}
+	assertFactor(t, tr, l, throttleMinFactor)
+}
+
+func Test_Throttle_Succeeded(t *testing.T) {
+	tr, l, advance := newTestThrottle(t, nil)
+
+	// Succeeding without throttling keeps the configured rates.
+	tr.Succeeded()
+	assertFactor(t, tr, l, 1)
+
+	tr.Throttled(time.Second)
+	advance(2 * time.Second)
+	tr.Throttled(time.Second)
+	assertFactor(t, tr, l, 0.25)
+
+	tr.Succeeded()
+	assertFactor(t, tr, l, 0.25+throttleRecoveryStep)
+
+	// Rates are restored up to the configured ones.
+	for i := 0; i < 100; i++ {
+		tr.Succeeded()
+	}
+	assertFactor(t, tr, l, 1)
+}
+
+func Test_Throttle_Observe(t *testing.T) {
+	testCases := []struct {
+		name         string
+		status       int
+		header       map[string]string
+		factor       float64
+		remaining    int64
+		limit        int64
+		blockedUntil time.Time
+	}{
+		{
+			name:      "case 0: response without quota",
+			status:    http.StatusOK,
+			factor:    1,
+			remaining: -1,
+			limit:     -1,
+		},
+		{
+			name:      "case 1: enough quota",
+			status:    http.StatusOK,
+			header:    map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "50"},
+			factor:    1,
+			remaining: 50,
+			limit:     100,
+		},
+		{
+			name:      "case 2: low quota slows down proportionally",
+			status:    http.StatusOK,
+			header:    map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "5"},
+			factor:    0.5,
+			remaining: 5,
+			limit:     100,
+		},
+		{
+			name:      "case 3: Docker Hub quota with parameters",
+			status:    http.StatusOK,
+			header:    map[string]string{"RateLimit-Limit": "100;w=21600", "RateLimit-Remaining": "2;w=21600"},
+			factor:    0.2,
+			remaining: 2,
+			limit:     100,
+		},
+		{
+			name:      "case 4: X-RateLimit headers",
+			status:    http.StatusOK,
+			header:    map[string]string{"X-RateLimit-Limit": "1000", "X-RateLimit-Remaining": "25"},
+			factor:    0.25,
+			remaining: 25,
+			limit:     1000,
+		},
+		{
+			name:         "case 5: exhausted quota with reset in seconds",
+			status:       http.StatusOK,
+			header:       map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "0", "RateLimit-Reset": "60"},
+			factor:       throttleMinFactor,
+			remaining:    0,
+			limit:        100,
+			blockedUntil: testNow.Add(time.Minute),
+		},
+		{
+			name:         "case 6: exhausted quota with reset as Unix timestamp",
+			status:       http.StatusOK,
+			header:       map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(testNow.Add(2*time.Hour).Unix(), 10)},
+			factor:       throttleMinFactor,
+			remaining:    0,
+			limit:        100,
+			blockedUntil: testNow.Add(2 * time.Hour),
+		},
+		{
+			name:         "case 7: too many requests with Retry-After in seconds",
+			status:       http.StatusTooManyRequests,
+			header:       map[string]string{"Retry-After": "30"},
+			factor:       0.5,
+			remaining:    -1,
+			limit:        -1,
+			blockedUntil: testNow.Add(30 * time.Second),
+		},
+		{
+			name:         "case 8: too many requests with Retry-After as date",
+			status:       http.StatusTooManyRequests,
+			header:       map[string]string{"Retry-After": testNow.Add(45 * time.Second).Format(http.TimeFormat)},
+			factor:       0.5,
+			remaining:    -1,
+			limit:        -1,
+			blockedUntil: testNow.Add(45 * time.Second),
+		},
+		{
+			name:         "case 9: too many requests without Retry-After",
+			status:       http.StatusTooManyRequests,
+			factor:       0.5,
+			remaining:    -1,
+			limit:        -1,
+			blockedUntil: testNow.Add(defaultRetryAfter),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, l, _ := newTestThrottle(t, nil)
+
+			resp := &http.Response{
+				StatusCode: tc.status,
+				Header:     http.Header{},
+			}
+			for k, v := range tc.header {
+				resp.Header.Set(k, v)
+			}
+
+			tr.Observe(resp)
+
+			assertFactor(t, tr, l, tc.factor)
+			assertBlockedUntil(t, tr, tc.blockedUntil)
+
+			s := tr.State()
+			if s.Remaining != tc.remaining || s.Limit != tc.limit {
+				t.Fatalf("quota == %d/%d, want %d/%d", s.Remaining, s.Limit, tc.remaining, tc.limit)
+			}
+		})
+	}
+}
+
+func Test_Throttle_EffectiveFactor(t *testing.T) {
+	tr, l, _ := newTestThrottle(t, nil)
+
+	quota := func(remaining int) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Ratelimit-Limit":     []string{"100"},
+				"Ratelimit-Remaining": []string{fmt.Sprint(remaining)},
+			},
+		}
+	}
+
+	// The lower of the quota factor and the factor lowered by throttling
+	// applies.
+	tr.Observe(quota(5))
+	assertFactor(t, tr, l, 0.5)
+
+	tr.Throttled(time.Second)
+	tr.Throttled(0)
+	assertFactor(t, tr, l, 0.5)
+
+	tr.Observe(quota(2))
+	assertFactor(t, tr, l, 0.2)
+
+	// Recovering quota leaves the rates lowered by throttling.
+	tr.Observe(quota(100))
+	assertFactor(t, tr, l, 0.5)
+}
+
+func Test_Throttle_OnChange(t *testing.T) {
+	var states []ThrottleState
+	tr, _, _ := newTestThrottle(t, func(s ThrottleState) {
+		states = append(states, s)
+	})
+
+	tr.Throttled(time.Second)
+	tr.Succeeded()
+
+	// Registering the limiter, throttling and succeeding are reported.
+	if len(states) != 3 {
+		t.Fatalf("%d states reported, want 3", len(states))
+	}
+	last := states[len(states)-1]
+	if math.Abs(last.Factor-(0.5+throttleRecoveryStep)) > 1e-9 {
+		t.Fatalf("factor == %v, want %v", last.Factor, 0.5+throttleRecoveryStep)
+	}
+	if r := last.Rates[OperationPull]; math.Abs(r-10*(0.5+throttleRecoveryStep)) > 1e-9 {
+		t.Fatalf("rate == %v, want %v", r, 10*(0.5+throttleRecoveryStep))
+	}
+}
@@ -0,0 +1,300 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	tagsBucket         = []byte("tags")
+	repositoriesBucket = []byte("repositories")
+)
+
+type BoltConfig struct {
+	// Path is the database file. It is created when it does not exist.
+	Path string
+}
+
+// Bolt is a Store keeping the state in an embedded bbolt database file. The
+// file is locked while it is open so it can be used by a single process.
+type Bolt struct {
+	db *bolt.DB
+}
+
+func NewBolt(c BoltConfig) (*Bolt, error) {
+	if c.Path == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Path must not be empty", c)
+	}
+
+	db, err := bolt.Open(c.Path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to open state file %#q with error: %s", c.Path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{tagsBucket, repositoriesBucket} {
+			_, err := tx.CreateBucketIfNotExists(b)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, microerror.Mask(err)
+	}
+
+	return &Bolt{
+		db: db,
+	}, nil
+}
+
+// LoadBolt returns a Memory store with a copy of the state in the database
+// file, which is opened read-only. Changes of the returned store are never
+// written back. A missing file results in an empty store.
+func LoadBolt(c BoltConfig) (*Memory, error) {
+	if c.Path == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.Path must not be empty", c)
+	}
+
+	m := NewMemory()
+
+	_, err := os.Stat(c.Path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	db, err := bolt.Open(c.Path, 0600, &bolt.Options{ReadOnly: true, Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, microerror.Maskf(executionFailedError, "failed to open state file %#q with error: %s", c.Path, err)
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(tagsBucket); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				var t Tag
+				err := json.Unmarshal(v, &t)
+				if err != nil {
+					return microerror.Mask(err)
+				}
+				m.tags[string(k)] = t
+				return nil
+			})
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+
+		if b := tx.Bucket(repositoriesBucket); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				var r Repository
+				err := json.Unmarshal(v, &r)
+				if err != nil {
+					return microerror.Mask(err)
+				}
+				m.repositories[string(k)] = r
+				return nil
+			})
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return m, nil
+}
+
+func (b *Bolt) GetTag(ctx context.Context, registry, repository, tag string) (Tag, bool, error) {
+	var t Tag
+
+	ok, err := b.get(tagsBucket, key(registry, repository, tag), &t)
+	if err != nil {
+		return Tag{}, false, microerror.Mask(err)
+	}
+
+	return t, ok, nil
+}
+
+func (b *Bolt) PutTag(ctx context.Context, t Tag) error {
+	err := b.put(tagsBucket, key(t.Registry, t.Repository, t.Tag), t)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// PutTags writes all the tags in a single transaction so recording a whole
+// repository costs a single sync of the file.
+func (b *Bolt) PutTags(ctx context.Context, tags []Tag) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, t := range tags {
+			data, err := json.Marshal(t)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+
+			err = tx.Bucket(tagsBucket).Put([]byte(key(t.Registry, t.Repository, t.Tag)), data)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (b *Bolt) DeleteTag(ctx context.Context, registry, repository, tag string) error {
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(tagsBucket).Delete([]byte(key(registry, repository, tag)))
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (b *Bolt) Tags(ctx context.Context, registry, repository string) ([]Tag, error) {
+	var tags []Tag
+	err := b.scan(tagsBucket, prefix(registry, repository), func(v []byte) error {
+		var t Tag
+		err := json.Unmarshal(v, &t)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		tags = append(tags, t)
+		return nil
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return tags, nil
+}
+
+func (b *Bolt) GetRepository(ctx context.Context, registry, repository string) (Repository, bool, error) {
+	var r Repository
+
+	ok, err := b.get(repositoriesBucket, key(registry, repository), &r)
+	if err != nil {
+		return Repository{}, false, microerror.Mask(err)
+	}
+
+	return r, ok, nil
+}
+
+func (b *Bolt) PutRepository(ctx context.Context, r Repository) error {
+	err := b.put(repositoriesBucket, key(r.Registry, r.Repository), r)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+func (b *Bolt) Repositories(ctx context.Context, registry string) ([]Repository, error) {
+	var repositories []Repository
+	err := b.scan(repositoriesBucket, prefix(registry), func(v []byte) error {
+		var r Repository
+		err := json.Unmarshal(v, &r)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		repositories = append(repositories, r)
+		return nil
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return repositories, nil
+}
+
+func (b *Bolt) Close() error {
+	return microerror.Mask(b.db.Close())
+}
+
+func (b *Bolt) get(bucket []byte, k string, v interface{}) (bool, error) {
+	var data []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// The returned value is only valid within the transaction.
+		data = append(data, tx.Bucket(bucket).Get([]byte(k))...)
+		return nil
+	})
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+	if len(data) == 0 {
+		return false, nil
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	return true, nil
+}
+
+// put writes a single value. Writes of parallel workers are batched into
+// a single transaction so they do not sync the file one by one.
+func (b *Bolt) put(bucket []byte, k string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = b.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(k), data)
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// scan calls f with values of all keys of the bucket starting with p in key
+// order.
+func (b *Bolt) scan(bucket []byte, p string, f func(v []byte) error) error {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.Seek([]byte(p)); k != nil && bytes.HasPrefix(k, []byte(p)); k, v = c.Next() {
+			err := f(v)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package state
+
+import "github.com/giantswarm/microerror"
+
+// executionFailedError should never be matched against and therefore there is
+// no matcher implement. For further information see:
+//
+//	https://github.com/giantswarm/fmt/blob/master/go/errors.md#matching-errors
+var executionFailedError = &microerror.Error{
+	Kind: "executionFailedError",
+}
+
+// IsExecutionFailed asserts executionFailedError.
+func IsExecutionFailed(err error) bool {
+	return microerror.Cause(err) == executionFailedError
+}
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError.
+func IsInvalidConfig(err error) bool {
+	return microerror.Cause(err) == invalidConfigError
+}
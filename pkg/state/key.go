@@ -0,0 +1,15 @@
+package state
+
+import "strings"
+
+// separator can not be a part of registry, repository or tag names.
+const separator = "\x00"
+
+func key(parts ...string) string {
+	return strings.Join(parts, separator)
+}
+
+// prefix returns the key prefix of all keys starting with parts.
+func prefix(parts ...string) string {
+	return key(parts...) + separator
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory is a Store keeping the state in memory. The state is lost when the
+// process exits.
+type Memory struct {
+	mutex        sync.Mutex
+	tags         map[string]Tag
+	repositories map[string]Repository
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		tags:         map[string]Tag{},
+		repositories: map[string]Repository{},
+	}
+}
+
+func (m *Memory) GetTag(ctx context.Context, registry, repository, tag string) (Tag, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t, ok := m.tags[key(registry, repository, tag)]
+	return t, ok, nil
+}
+
+func (m *Memory) PutTag(ctx context.Context, t Tag) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.tags[key(t.Registry, t.Repository, t.Tag)] = t
+	return nil
+}
+
+func (m *Memory) PutTags(ctx context.Context, tags []Tag) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, t := range tags {
+		m.tags[key(t.Registry, t.Repository, t.Tag)] = t
+	}
+	return nil
+}
+
+func (m *Memory) DeleteTag(ctx context.Context, registry, repository, tag string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.tags, key(registry, repository, tag))
+	return nil
+}
+
+func (m *Memory) Tags(ctx context.Context, registry, repository string) ([]Tag, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p := prefix(registry, repository)
+
+	var keys []string
+	for k := range m.tags {
+		if strings.HasPrefix(k, p) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var tags []Tag
+	for _, k := range keys {
+		tags = append(tags, m.tags[k])
+	}
+
+	return tags, nil
+}
+
+func (m *Memory) GetRepository(ctx context.Context, registry, repository string) (Repository, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, ok := m.repositories[key(registry, repository)]
+	return r, ok, nil
+}
+
+func (m *Memory) PutRepository(ctx context.Context, r Repository) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.repositories[key(r.Registry, r.Repository)] = r
+	return nil
+}
+
+func (m *Memory) Repositories(ctx context.Context, registry string) ([]Repository, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p := prefix(registry)
+
+	var keys []string
+	for k := range m.repositories {
+		if strings.HasPrefix(k, p) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var repositories []Repository
+	for _, k := range keys {
+		repositories = append(repositories, m.repositories[k])
+	}
+
+	return repositories, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,25 @@
+package state
+
+import "context"
+
+// Store persists the sync state so it survives restarts of crsync.
+type Store interface {
+	// GetTag returns the state of the tag in the destination registry. The
+	// returned bool is false when there is no state recorded.
+	GetTag(ctx context.Context, registry, repository, tag string) (Tag, bool, error)
+	PutTag(ctx context.Context, t Tag) error
+	// PutTags records states of multiple tags at once, in a single
+	// transaction of persistent stores.
+	PutTags(ctx context.Context, tags []Tag) error
+	DeleteTag(ctx context.Context, registry, repository, tag string) error
+	// Tags returns states of all tags of the destination repository.
+	Tags(ctx context.Context, registry, repository string) ([]Tag, error)
+	// GetRepository returns the state of the source repository. The
+	// returned bool is false when there is no state recorded.
+	GetRepository(ctx context.Context, registry, repository string) (Repository, bool, error)
+	PutRepository(ctx context.Context, r Repository) error
+	// Repositories returns states of all source repositories of the
+	// registry.
+	Repositories(ctx context.Context, registry string) ([]Repository, error)
+	Close() error
+}
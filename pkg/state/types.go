@@ -0,0 +1,40 @@
+package state
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Tag is the state of a tag in a destination registry.
+type Tag struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+
+	SourceRepository string `json:"sourceRepository,omitempty"`
+	SourceTag        string `json:"sourceTag,omitempty"`
+	// Digest is the manifest digest of the tag in the destination registry.
+	Digest digest.Digest `json:"digest,omitempty"`
+	// SyncedAt is the time the tag was last copied or found in sync.
+	SyncedAt    time.Time `json:"syncedAt,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+	// AbsentSince is the time the tag was first found absent in the source
+	// registry in mirror mode.
+	AbsentSince time.Time `json:"absentSince,omitempty"`
+}
+
+// Repository is the state of a source repository.
+type Repository struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+
+	// Fingerprint identifies the source tags with their digests and
+	// destinations they were synced to. It is set only when the last sync
+	// of the repository completed without errors.
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	SyncedAt    time.Time `json:"syncedAt,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+}
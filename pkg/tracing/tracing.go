@@ -0,0 +1,132 @@
+// Package tracing sets up OpenTelemetry tracer providers exporting spans
+// over OTLP or writing them as JSON for local testing.
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/giantswarm/microerror"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterOTLPHTTP = "otlp-http"
+	ExporterStdout   = "stdout"
+)
+
+type Config struct {
+	// Exporter is ExporterOTLPGRPC, ExporterOTLPHTTP or ExporterStdout.
+	Exporter string
+	// Endpoint is the host:port of the OTLP collector. It defaults to
+	// OTEL_EXPORTER_OTLP_ENDPOINT and the exporter default.
+	Endpoint string
+	// Insecure disables TLS towards the OTLP collector.
+	Insecure bool
+	// IOWriter is where ExporterStdout writes spans. Defaults to the
+	// standard output.
+	IOWriter io.Writer
+
+	ServiceName    string
+	ServiceVersion string
+}
+
+// New returns a tracer provider exporting spans in batches. It must be shut
+// down to flush pending spans. Sampling is configured with standard
+// OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG variables.
+func New(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	if config.ServiceName == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%T.ServiceName must not be empty", config)
+	}
+	if config.IOWriter == nil {
+		config.IOWriter = os.Stdout
+	}
+
+	var err error
+
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case ExporterOTLPGRPC:
+		var options []otlptracegrpc.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err = otlptracegrpc.New(ctx, options...)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	case ExporterOTLPHTTP:
+		var options []otlptracehttp.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err = otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(config.IOWriter))
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	default:
+		return nil, microerror.Maskf(invalidConfigError, "%T.Exporter must be one of %#q, %#q or %#q", config, ExporterOTLPGRPC, ExporterOTLPHTTP, ExporterStdout)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(config.ServiceName),
+			semconv.ServiceVersion(config.ServiceVersion),
+		),
+	)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	return provider, nil
+}
+
+// Tracer returns the named tracer of provider or a tracer creating no spans
+// when provider is nil.
+func Tracer(provider trace.TracerProvider, name string) trace.Tracer {
+	if provider == nil {
+		provider = noop.NewTracerProvider()
+	}
+
+	return provider.Tracer(name)
+}
+
+// End records the error on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}